@@ -0,0 +1,76 @@
+package testfs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+
+	if !c.Now().Equal(start) {
+		t.Error("Bad time")
+	}
+
+	c.Advance(time.Hour)
+	if !c.Now().Equal(start.Add(time.Hour)) {
+		t.Error("Bad time")
+	}
+
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Error("Bad time")
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+
+	testfs := NewTestFS(0, 0)
+	testfs.SetClock(c)
+
+	err := testfs.Mkdir("/clock", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := testfs.Stat("/clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(start) {
+		t.Error("Bad modtime", fi.ModTime())
+	}
+
+	c.Advance(time.Minute)
+
+	// The clock is shared with other handles
+	f, err := testfs.As(0, 0).Create("/clock/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c.Advance(time.Minute)
+
+	f.Write([]byte("test data"))
+
+	st := f.(*file).inode.Sys().(*Stat_t)
+	if !st.Btime.Equal(start.Add(time.Minute)) {
+		t.Error("Bad birth time", st.Btime)
+	}
+	if !st.Mtime.Equal(start.Add(2 * time.Minute)) {
+		t.Error("Bad modtime", st.Mtime)
+	}
+
+	fi, err = testfs.Stat("/clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(start.Add(time.Minute)) {
+		t.Error("Bad directory modtime", fi.ModTime())
+	}
+}
@@ -0,0 +1,503 @@
+package testfs
+
+// Differential testing of TestFS against the real filesystem.  The same
+// random sequence of operations is run against a TestFS and against an osfs
+// rooted in a temporary directory.  The result of every operation, and the
+// state of both trees after it, must match.  A sequence which diverges is
+// shrunk to a minimal reproducer before being reported.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var (
+	diffSeed = flag.Int64("diff.seed", 1, "seed for the differential test, 0 for a random seed")
+	diffRuns = flag.Int("diff.runs", 200, "number of random sequences for the differential test")
+	diffOps  = flag.Int("diff.ops", 40, "number of operations in each sequence")
+)
+
+// Number of open file slots an operation sequence may use.
+const diffSlots = 2
+
+// Symlink targets never climb out of the directory holding the link, as
+// the real filesystem carries on above the root where TestFS stops.
+var (
+	diffNames   = []string{"a", "b", "c"}
+	diffTargets = []string{"a", "b", "a/b", "a/../b", "missing"}
+	diffPerms   = []os.FileMode{0000, 0400, 0500, 0600, 0644, 0700, 0755}
+	diffFlags   = []int{
+		os.O_RDONLY,
+		os.O_WRONLY,
+		os.O_RDWR,
+		os.O_WRONLY | os.O_CREATE,
+		os.O_RDWR | os.O_CREATE,
+		os.O_RDWR | os.O_CREATE | os.O_EXCL,
+		os.O_RDONLY | os.O_TRUNC,
+		os.O_WRONLY | os.O_APPEND,
+		os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+		os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	}
+)
+
+// diffOp is a single step of an operation sequence.  Paths are relative to
+// the root of the filesystem under test.
+type diffOp struct {
+	kind   string
+	path   string
+	path2  string
+	slot   int
+	perm   os.FileMode
+	flag   int
+	off    int64
+	whence int
+	data   string
+}
+
+func (o diffOp) String() string {
+	switch o.kind {
+
+	case "mkdir", "mkdirall", "chmod":
+		return fmt.Sprintf("%s %q %#o", o.kind, o.path, o.perm)
+
+	case "rename", "link", "symlink":
+		return fmt.Sprintf("%s %q %q", o.kind, o.path, o.path2)
+
+	case "truncate":
+		return fmt.Sprintf("%s %q %d", o.kind, o.path, o.off)
+
+	case "open":
+		return fmt.Sprintf("%s [%d] %q %#x %#o", o.kind, o.slot, o.path, o.flag, o.perm)
+
+	case "close", "fstat":
+		return fmt.Sprintf("%s [%d]", o.kind, o.slot)
+
+	case "write":
+		return fmt.Sprintf("%s [%d] %q", o.kind, o.slot, o.data)
+
+	case "writeat":
+		return fmt.Sprintf("%s [%d] %q %d", o.kind, o.slot, o.data, o.off)
+
+	case "read", "readat", "ftruncate":
+		return fmt.Sprintf("%s [%d] %d", o.kind, o.slot, o.off)
+
+	case "seek":
+		return fmt.Sprintf("%s [%d] %d %d", o.kind, o.slot, o.off, o.whence)
+
+	default:
+		return fmt.Sprintf("%s %q", o.kind, o.path)
+
+	}
+}
+
+func randPath(r *rand.Rand) string {
+	p := diffNames[r.Intn(len(diffNames))]
+	if r.Intn(2) == 0 {
+		p += "/" + diffNames[r.Intn(len(diffNames))]
+	}
+	if r.Intn(8) == 0 {
+		p += "/"
+	}
+	return p
+}
+
+func randData(r *rand.Rand) string {
+	b := make([]byte, 1+r.Intn(8))
+	for i := range b {
+		b[i] = byte('a' + r.Intn(26))
+	}
+	return string(b)
+}
+
+// Generate a random operation.
+func randOp(r *rand.Rand) diffOp {
+	kinds := []string{
+		"mkdir", "mkdirall", "remove", "removeall", "rename", "link",
+		"symlink", "readlink", "truncate", "chmod", "stat", "lstat",
+		"readdir", "open", "open", "close", "write", "writeat", "read",
+		"readat", "ftruncate", "fstat", "seek",
+	}
+
+	o := diffOp{
+		kind:   kinds[r.Intn(len(kinds))],
+		path:   randPath(r),
+		path2:  randPath(r),
+		slot:   r.Intn(diffSlots),
+		perm:   diffPerms[r.Intn(len(diffPerms))],
+		flag:   diffFlags[r.Intn(len(diffFlags))],
+		off:    int64(r.Intn(12)),
+		whence: r.Intn(5),
+		data:   randData(r),
+	}
+
+	if o.kind == "symlink" {
+		o.path = diffTargets[r.Intn(len(diffTargets))]
+	}
+
+	// Sometimes reach into later blocks, to leave holes behind
+	if r.Intn(4) == 0 {
+		o.off += int64(1+r.Intn(2)) * 4096
+	}
+
+	return o
+}
+
+// diffEnv is one side of a differential run.
+type diffEnv struct {
+	fs    FileSystem
+	root  string
+	files [diffSlots]File
+}
+
+func (e *diffEnv) path(p string) string {
+	return e.root + "/" + p
+}
+
+func (e *diffEnv) close() {
+	for i, f := range e.files {
+		if f != nil {
+			f.Close()
+			e.files[i] = nil
+		}
+	}
+}
+
+// Reduce an error to its errno, or failing that its innermost message, so
+// that errors from both sides can be compared.
+func errKey(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno.Error()
+	}
+
+	for errors.Unwrap(err) != nil {
+		err = errors.Unwrap(err)
+	}
+	return err.Error()
+}
+
+// Describe the parts of fi which both filesystems should agree on.
+func infoKey(fi os.FileInfo) string {
+	if fi == nil {
+		return "-"
+	}
+
+	mode := fi.Mode()
+	s := fmt.Sprintf("%s %v", fi.Name(), mode.Type())
+
+	switch {
+
+	case mode&os.ModeSymlink != 0:
+		s += fmt.Sprintf(" size=%d", fi.Size())
+
+	case mode.IsRegular():
+		s += fmt.Sprintf(" %v size=%d", mode.Perm(), fi.Size())
+
+	default:
+		s += fmt.Sprintf(" %v", mode.Perm())
+
+	}
+
+	return s
+}
+
+// Apply a single operation, returning a description of its result.
+func (e *diffEnv) apply(o diffOp) string {
+	switch o.kind {
+
+	case "mkdir":
+		return errKey(e.fs.Mkdir(e.path(o.path), o.perm))
+
+	case "mkdirall":
+		return errKey(e.fs.MkdirAll(e.path(o.path), o.perm))
+
+	case "remove":
+		return errKey(e.fs.Remove(e.path(o.path)))
+
+	case "removeall":
+		return errKey(e.fs.RemoveAll(e.path(o.path)))
+
+	case "rename":
+		return errKey(e.fs.Rename(e.path(o.path), e.path(o.path2)))
+
+	case "link":
+		return errKey(e.fs.Link(e.path(o.path), e.path(o.path2)))
+
+	case "symlink":
+		return errKey(e.fs.Symlink(o.path, e.path(o.path2)))
+
+	case "readlink":
+		target, err := e.fs.Readlink(e.path(o.path))
+		return target + " " + errKey(err)
+
+	case "truncate":
+		return errKey(e.fs.Truncate(e.path(o.path), o.off))
+
+	case "chmod":
+		return errKey(e.fs.Chmod(e.path(o.path), o.perm))
+
+	case "stat":
+		fi, err := e.fs.Stat(e.path(o.path))
+		return infoKey(fi) + " " + errKey(err)
+
+	case "lstat":
+		fi, err := e.fs.Lstat(e.path(o.path))
+		return infoKey(fi) + " " + errKey(err)
+
+	case "readdir":
+		names, err := e.readdir(o.path)
+		return strings.Join(names, ",") + " " + errKey(err)
+
+	case "open":
+		if e.files[o.slot] != nil {
+			e.files[o.slot].Close()
+			e.files[o.slot] = nil
+		}
+		f, err := e.fs.OpenFile(e.path(o.path), o.flag, o.perm)
+		if err == nil {
+			e.files[o.slot] = f
+		}
+		return errKey(err)
+
+	}
+
+	// The remaining operations act on an open file.
+	f := e.files[o.slot]
+	if f == nil {
+		return "no file"
+	}
+
+	switch o.kind {
+
+	case "close":
+		// The file stays in its slot, so later operations check how
+		// closed files behave.
+		return errKey(f.Close())
+
+	case "write":
+		n, err := f.Write([]byte(o.data))
+		return fmt.Sprintf("%d %s", n, errKey(err))
+
+	case "writeat":
+		n, err := f.WriteAt([]byte(o.data), o.off)
+		// On Unix, os only notices a closed file when it makes the
+		// system call, so it reports O_APPEND first.  TestFS reports
+		// the closed file first.
+		if err != nil && err.Error() == errWriteAtInAppendMode.Error() {
+			if _, serr := f.Stat(); errors.Is(serr, os.ErrClosed) {
+				err = os.ErrClosed
+			}
+		}
+		return fmt.Sprintf("%d %s", n, errKey(err))
+
+	case "read":
+		buf := make([]byte, o.off)
+		n, err := f.Read(buf)
+		return fmt.Sprintf("%q %s", buf[:n], errKey(err))
+
+	case "readat":
+		buf := make([]byte, 4)
+		n, err := f.ReadAt(buf, o.off)
+		return fmt.Sprintf("%q %s", buf[:n], errKey(err))
+
+	case "seek":
+		// Offsets in directories mean something different to each
+		// filesystem
+		if fi, err := f.Stat(); err == nil && fi.IsDir() {
+			return "directory"
+		}
+		ret, err := f.Seek(o.off, o.whence)
+		return fmt.Sprintf("%d %s", ret, errKey(err))
+
+	case "ftruncate":
+		return errKey(f.Truncate(o.off))
+
+	case "fstat":
+		fi, err := f.Stat()
+		return infoKey(fi) + " " + errKey(err)
+
+	}
+
+	panic("unknown operation " + o.kind)
+}
+
+// Return the sorted contents of a directory.
+func (e *diffEnv) readdir(p string) ([]string, error) {
+	f, err := e.fs.Open(e.path(p))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	sort.Strings(names)
+	return names, err
+}
+
+// Describe the whole tree, one line per entry.  The root itself is left
+// out, as the temporary directory need not match the TestFS root.
+func (e *diffEnv) tree() []string {
+	names, err := e.readdir("")
+	lines := []string{errKey(err)}
+	for _, name := range names {
+		lines = e.snapshot(name, lines)
+	}
+	return lines
+}
+
+// Describe p and everything below it, one line per entry.
+func (e *diffEnv) snapshot(p string, lines []string) []string {
+	fi, err := e.fs.Lstat(e.path(p))
+	line := p + ": " + infoKey(fi) + " " + errKey(err)
+
+	if err != nil {
+		return append(lines, line)
+	}
+
+	switch {
+
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := e.fs.Readlink(e.path(p))
+		lines = append(lines, line+" -> "+target+" "+errKey(err))
+
+	case fi.IsDir():
+		names, err := e.readdir(p)
+		lines = append(lines, line+" "+errKey(err))
+		for _, name := range names {
+			lines = e.snapshot(p+"/"+name, lines)
+		}
+
+	default:
+		var data []byte
+		f, err := e.fs.Open(e.path(p))
+		if err == nil {
+			data, err = ioutil.ReadAll(f)
+			f.Close()
+		}
+		lines = append(lines, fmt.Sprintf("%s %q %s", line, data, errKey(err)))
+
+	}
+
+	return lines
+}
+
+// Run ops against a TestFS and an osfs, returning the index of the first
+// operation where they diverge and a description of the difference, or -1
+// if they agree throughout.
+func runDiff(t *testing.T, ops []diffOp) (int, string) {
+	dir, err := ioutil.TempDir("", "testfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var groups []int
+	if g, err := os.Getgroups(); err == nil {
+		groups = g
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	mem := &diffEnv{fs: NewTestFS(uid, gid).As(uid, gid, groups...)}
+	disk := &diffEnv{fs: NewOSFS(), root: dir}
+	defer mem.close()
+	defer disk.close()
+
+	for i, o := range ops {
+		want := disk.apply(o)
+		got := mem.apply(o)
+		if got != want {
+			return i, fmt.Sprintf("%v: got %s, want %s", o, got, want)
+		}
+
+		gotTree := strings.Join(mem.tree(), "\n\t     ")
+		wantTree := strings.Join(disk.tree(), "\n\t     ")
+		if gotTree != wantTree {
+			return i, fmt.Sprintf("%v: tree differs:\n\tgot  %s\n\twant %s", o, gotTree, wantTree)
+		}
+	}
+
+	return -1, ""
+}
+
+// Shrink a diverging sequence by removing operations for as long as it
+// still diverges.
+func shrinkDiff(t *testing.T, ops []diffOp) []diffOp {
+	for chunk := len(ops) / 2; chunk >= 1; {
+		shrunk := false
+
+		for i := 0; i+chunk <= len(ops); {
+			try := append(append([]diffOp(nil), ops[:i]...), ops[i+chunk:]...)
+			if n, _ := runDiff(t, try); n >= 0 {
+				ops = try[:n+1]
+				shrunk = true
+			} else {
+				i += chunk
+			}
+		}
+
+		if !shrunk || chunk > 1 {
+			chunk /= 2
+		}
+	}
+
+	return ops
+}
+
+func TestDifferential(t *testing.T) {
+	// Root passes every permission check on both filesystems, which would
+	// hide any difference in them.
+	if os.Getuid() == 0 {
+		t.Skip("the differential test must run as a non-root user")
+	}
+
+	seed := *diffSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	runs := *diffRuns
+	if testing.Short() {
+		runs /= 10
+	}
+
+	// Give the real filesystem the default umask of TestFS.
+	defer syscall.Umask(syscall.Umask(defaultUmask))
+
+	r := rand.New(rand.NewSource(seed))
+
+	for run := 0; run < runs; run++ {
+		ops := make([]diffOp, *diffOps)
+		for i := range ops {
+			ops[i] = randOp(r)
+		}
+
+		n, _ := runDiff(t, ops)
+		if n < 0 {
+			continue
+		}
+
+		ops = shrinkDiff(t, ops[:n+1])
+		_, msg := runDiff(t, ops)
+
+		steps := make([]string, len(ops))
+		for i := range ops {
+			steps[i] = ops[i].String()
+		}
+
+		t.Fatalf("TestFS differs from the OS (seed %d):\n\t%s\n%s",
+			seed, strings.Join(steps, "\n\t"), msg)
+	}
+}
@@ -1,123 +1,371 @@
 package testfs
 
 import (
+	"fmt"
+	"math"
 	"os"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const sep = "/"
 const inodeAllocSize = 4096
 
+// The default number of symlinks which may be followed while resolving a
+// single path, as on Linux.
+const defaultMaxSymlinks = 40
+
+// The default file mode creation mask, as most systems give a login shell.
+const defaultUmask = 0022
+
 var (
-	Uid, Gid uint16
+	// Uid and Gid are the credentials given to a newly created TestFS.
+	// Use TestFS.As to act as a different user on an existing filesystem.
+	Uid, Gid uint32
 	fd       fdCtr
+	pid      fdCtr
 )
 
 func init() {
-	Uid = uint16(os.Getuid())
-	Gid = uint16(os.Getgid())
+	Uid = localID(os.Getuid())
+	Gid = localID(os.Getgid())
 	fd.ctr = 0
 }
 
+// Convert an id of the running process.  Platforms without them, such as
+// Windows, report -1, so act as root there.
+func localID(id int) uint32 {
+	if id < 0 {
+		return 0
+	}
+	return uint32(id)
+}
+
+// Check that id fits in a uid or gid.  Ids are 32 bits, and the largest is
+// reserved to mean no id, as on Linux.  Bad ids are a mistake in the test
+// rather than something to handle, so they panic.
+func checkID(id int) uint32 {
+	if id < 0 || int64(id) >= math.MaxUint32 {
+		panic(fmt.Sprintf("testfs: invalid uid or gid %d", id))
+	}
+	return uint32(id)
+}
+
 // inode represents an entity in the filesystem.  Children are represented as
 // pointers to allow us to simulate hardlinks.  This is not entirely like a POSIX
 // inode, but is named as this to clarify that it can refer to any sort of FS object.
+//
+// mu guards the children of a directory.  The name and parent of an inode
+// only change with both it and its parent directory locked, so either lock
+// is enough to read them.  The parent only changes in a rename between
+// directories, so the rename lock is also enough to read it.  Directories are only ever locked parent first,
+// apart from in a rename between directories (see lockRename).
+// attr guards the attributes and data, from uid to data below.  No other inode is locked while attr is held,
+// so it may be taken with any directory locked.
 type inode struct {
 	name      string
-	uid       uint16
-	gid       uint16
+	uid       uint32
+	gid       uint32
 	mode      os.FileMode
 	xattrs    map[string]string
 	linkCount uint16
-	rel       *inode
-	relName   string
-	mtime     time.Time
-	data      []byte
+	openCount int32     // Open files referring to this inode
+	relName   string    // Symlink target
+	atime     time.Time // Last access
+	mtime     time.Time // Last modification of the contents
+	ctime     time.Time // Last change to the inode metadata
+	btime     time.Time // Creation (birth) time
+	data      fileData
 	children  map[string]*inode
+	parent    *inode    // Parent directory, for directories
+	locks     fileLocks // Guarded by sb.locks
 	mu        *sync.Mutex
+	attr      sync.RWMutex
+	sb        *superblock
 }
 
 // Stat_t is the data type returned by the Sys() interface for a testfs file.
+// The Atim, Mtim, Ctim and Btim fields hold the same timestamps as Atime,
+// Mtime, Ctime and Btime, in the form used by syscall.Stat_t.
 type Stat_t struct {
 	Name     string
-	Uid      uint16
-	Gid      uint16
+	Uid      uint32
+	Gid      uint32
 	Mode     os.FileMode
 	Xattrs   map[string]string
+	Atime    time.Time
 	Mtime    time.Time
+	Ctime    time.Time
+	Btime    time.Time
+	Atim     syscall.Timespec
+	Mtim     syscall.Timespec
+	Ctim     syscall.Timespec
+	Btim     syscall.Timespec
 	Size     int64
+	Nlink    uint64
+	Blocks   int64 // 512 byte blocks allocated, which holes do not use
+	Blksize  int64
 	Linkname string
 }
 
+// superblock holds the state shared by every handle and inode of a single
+// filesystem.
+type superblock struct {
+	mu          sync.Mutex
+	clock       Clock
+	maxSymlinks int
+	root        *inode
+
+	// rename is held by any rename which moves an entry between
+	// directories, as s_vfs_rename_mutex is on Linux.  Nothing else can
+	// change the shape of the tree, so while it is held the parent of
+	// every directory stays put.
+	rename sync.Mutex
+
+	// locks guards the file locks of every inode, and waits, which holds
+	// the processes blocked on a lock for deadlock detection.
+	locks sync.Mutex
+	waits map[*lockWait]struct{}
+}
+
+// now returns the current time according to the filesystem clock.
+func (s *superblock) now() time.Time {
+	s.mu.Lock()
+	c := s.clock
+	s.mu.Unlock()
+	return c.Now()
+}
+
+// symlinkLimit returns the number of symlinks a path lookup may follow.
+func (s *superblock) symlinkLimit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxSymlinks
+}
+
+// cred is the identity a TestFS handle acts as.  It is used for all
+// permission checks, and as the owner of any inodes the handle creates.
+type cred struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+	pid    int32  // Reported as the owner of the process locks of the handle
+	umask  uint32 // Permission bits cleared on create, used atomically
+}
+
+// applyUmask clears the bits of the umask of c from mode.
+func (c *cred) applyUmask(mode os.FileMode) os.FileMode {
+	return mode &^ os.FileMode(atomic.LoadUint32(&c.umask))
+}
+
+// inGroup reports whether gid is the primary group or one of the
+// supplementary groups of c.
+func (c *cred) inGroup(gid uint32) bool {
+	if c.gid == gid {
+		return true
+	}
+	for _, g := range c.groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
 // Create a new inode as a child of this one
-func (i *inode) new(name string, uid, gid uint16, mode os.FileMode) error {
+func (i *inode) new(c *cred, name string, uid, gid uint32, mode os.FileMode) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	return i.newSkipLock(name, uid, gid, mode)
+	return i.newSkipLock(c, name, uid, gid, mode)
 }
 
 // Unsafe.  This creates a new inode without locking.  Should only be used
 // if the calling function is locking seperately.
-func (i *inode) newSkipLock(name string, uid, gid uint16, mode os.FileMode) error {
-	if !checkPerm(i, 'w', 'x') {
-		return os.ErrPermission
+func (i *inode) newSkipLock(c *cred, name string, uid, gid uint32, mode os.FileMode) error {
+	if !i.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	// The checks follow filename_create on Linux: search access to i
+	// first, then whether the name is taken, and only then write access.
+	if !checkPerm(c, i, 'x') {
+		return syscall.EACCES
+	}
+	if name == "" || isDot(name) {
+		// These always exist
+		return syscall.EEXIST
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, ok := i.children[name]; ok {
+		return syscall.EEXIST
 	}
+
+	// Nothing may be created in a directory which has been removed
+	if i.dead() {
+		return syscall.ENOENT
+	}
+	if !checkPerm(c, i, 'w') {
+		return syscall.EACCES
+	}
+
+	// A setgid directory passes its group on to everything created in it,
+	// and its setgid bit to new directories.  A new group executable file
+	// only keeps the setgid bit if its creator is in the group.
+	i.attr.RLock()
+	if i.mode&os.ModeSetgid != 0 {
+		gid = i.gid
+		switch {
+		case mode.IsDir():
+			mode |= os.ModeSetgid
+		case mode&0010 != 0 && c.uid != 0 && !c.inGroup(gid):
+			mode &^= os.ModeSetgid
+		}
+	}
+	i.attr.RUnlock()
+
+	now := i.sb.now()
 	entry := inode{
 		mu:        new(sync.Mutex),
+		sb:        i.sb,
 		xattrs:    make(map[string]string),
 		name:      name,
 		uid:       uid,
 		gid:       gid,
 		mode:      mode,
-		mtime:     time.Now(),
+		atime:     now,
+		mtime:     now,
+		ctime:     now,
+		btime:     now,
 		linkCount: 1,
 	}
-	if i.IsDir() {
+	if mode.IsDir() {
 		entry.children = make(map[string]*inode)
-		entry.children[".."] = i
-	}
-	if _, ok := i.children[name]; ok {
-		return os.ErrExist
+		entry.parent = i
 	}
 	i.children[name] = &entry
-	i.mtime = time.Now()
+	i.touch(now)
 	return nil
 }
 
+// Report whether the last link to i has gone.
+func (i *inode) dead() bool {
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+	return i.linkCount == 0
+}
+
 // TestFS implements an in-memory filesystem.  We use maps rather than
 // slices to allow us to scale to large file numbers more efficiently.
 type TestFS struct {
-	dirTree inode
+	dirTree *inode
+	cwdMu   sync.Mutex // Guards cwd
 	cwd     *inode
-	cwdPath string
+	cred    cred
+	sb      *superblock
+}
+
+// Return the working directory of t.
+func (t *TestFS) wd() *inode {
+	t.cwdMu.Lock()
+	defer t.cwdMu.Unlock()
+	return t.cwd
 }
 
-// Creates and initialises a new TestFS filesystem.  Creating a TestFS
-// filesystem any other way is not supported.
+// Creates and initialises a new TestFS filesystem, with the root directory
+// owned by uid and gid.  Creating a TestFS filesystem any other way is not
+// supported.  NewTestFS panics if uid or gid does not fit in 32 bits.
 func NewTestFS(uid, gid int) *TestFS {
 	t := new(TestFS)
+	t.sb = &superblock{
+		clock:       realClock{},
+		maxSymlinks: defaultMaxSymlinks,
+		waits:       make(map[*lockWait]struct{}),
+	}
+	t.dirTree = new(inode)
+	t.dirTree.sb = t.sb
+	t.sb.root = t.dirTree
 	t.dirTree.children = make(map[string]*inode)
 	t.dirTree.mu = new(sync.Mutex)
-	t.dirTree.uid = uint16(uid)
-	t.dirTree.gid = uint16(gid)
+	t.dirTree.uid = checkID(uid)
+	t.dirTree.gid = checkID(gid)
 	t.dirTree.mode = os.FileMode(0755) | os.ModeDir
 	t.dirTree.xattrs = make(map[string]string)
 	t.dirTree.linkCount = 1
 	t.dirTree.name = sep
-	t.cwd = &t.dirTree
-	t.cwdPath = sep
+	now := t.sb.now()
+	t.dirTree.atime = now
+	t.dirTree.mtime = now
+	t.dirTree.ctime = now
+	t.dirTree.btime = now
+	t.cwd = t.dirTree
+	t.cred = cred{uid: Uid, gid: Gid, pid: int32(pid.next()), umask: defaultUmask}
 	return t
 }
 
+// As returns a handle on the same filesystem which acts as the given uid,
+// gid and supplementary groups.  The handle starts in the same working
+// directory as t, but each handle changes directory independently.  Each
+// handle also stands for a separate process when taking fcntl locks, and
+// starts with the umask of t.  As panics if an id does not fit in 32 bits.
+func (t *TestFS) As(uid, gid int, groups ...int) *TestFS {
+	h := new(TestFS)
+	h.sb = t.sb
+	h.dirTree = t.dirTree
+	h.cwd = t.wd()
+	h.cred.uid = checkID(uid)
+	h.cred.gid = checkID(gid)
+	h.cred.pid = int32(pid.next())
+	h.cred.umask = atomic.LoadUint32(&t.cred.umask)
+	h.cred.groups = make([]uint32, len(groups))
+	for n := range groups {
+		h.cred.groups[n] = checkID(groups[n])
+	}
+	return h
+}
+
 func NewLocalTestFS() *TestFS {
-	return NewTestFS(os.Getuid(), os.Getgid())
+	return NewTestFS(int(localID(os.Getuid())), int(localID(os.Getgid())))
+
+}
+
+// SetClock sets the clock used for every timestamp the filesystem records.
+// The clock is shared by all handles on the filesystem.  By default the
+// system clock is used.
+func (t *TestFS) SetClock(c Clock) {
+	t.sb.mu.Lock()
+	defer t.sb.mu.Unlock()
+	t.sb.clock = c
+}
+
+// SetMaxSymlinks sets the number of symlinks which may be followed while
+// resolving a single path.  Lookups which need more fail with ELOOP.  The
+// default is 40.
+func (t *TestFS) SetMaxSymlinks(n int) {
+	t.sb.mu.Lock()
+	defer t.sb.mu.Unlock()
+	t.sb.maxSymlinks = n
+}
 
+// Umask sets the file mode creation mask of the handle and returns the
+// previous one, as umask(2) does.  The permission bits in the mask are
+// cleared from the mode of every file and directory the handle creates.
+// Symlinks are not affected.  The default is 022.
+func (t *TestFS) Umask(mask os.FileMode) os.FileMode {
+	return os.FileMode(atomic.SwapUint32(&t.cred.umask, uint32(mask.Perm())))
 }
 
 // Split a filesystem path into elements.
 func parsePath(path string) ([]string, error) {
+	if len(path) >= pathMax {
+		return nil, syscall.ENAMETOOLONG
+	}
+
 	if path == sep {
 		return nil, nil
 	}
@@ -140,6 +388,9 @@ func parsePath(path string) ([]string, error) {
 			continue
 
 		default:
+			if len(elems[i]) > nameMax {
+				return nil, syscall.ENAMETOOLONG
+			}
 			terms = append(terms, elems[i])
 
 		}
@@ -149,77 +400,113 @@ func parsePath(path string) ([]string, error) {
 }
 
 // Look up child inodes, recursively if there is more than one term.
-func (i *inode) lookup(terms []string) (*inode, error) {
+// Symlinks are followed as they are found, except for a symlink in the
+// final element when follow is false.
+func (i *inode) lookup(c *cred, terms []string, follow bool) (*inode, error) {
+	links := 0
+	return i.walk(c, terms, follow, &links)
+}
+
+// walk does the work of lookup.  links counts the symlinks followed so far
+// in this lookup, so that loops are caught however they are reached.
+func (i *inode) walk(c *cred, terms []string, follow bool, links *int) (*inode, error) {
 
 	if len(terms) == 0 {
 		return i, nil
 	}
 
-	if !i.IsDir() {
-		return nil, os.ErrInvalid
+	// Only one directory is locked at a time, so a lookup never holds up
+	// anything else for long, and never takes locks out of order.
+	i.mu.Lock()
+	this, err := i.childSkipLock(c, terms[0])
+	i.mu.Unlock()
+	if err != nil {
+		return nil, err
 	}
 
-	if this, ok := i.children[terms[0]]; ok {
-
-		// Follow symlinks
-		if this.mode&os.ModeSymlink == os.ModeSymlink {
-			return this.rel.lookup(terms[1:])
+	// Follow symlinks
+	if this.Mode()&os.ModeSymlink == os.ModeSymlink && (follow || len(terms) > 1) {
+		this, err = this.readlink(c, i, links)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		// If we're at the end of the path, check for read perms and return it
-		if len(terms) == 1 {
+	return this.walk(c, terms[1:], follow, links)
+}
 
-			if !checkPerm(this, 'r') {
-				return nil, os.ErrPermission
-			}
+// Unsafe.  Look up the single entry name in the directory i, without
+// following symlinks.  The caller must hold i.mu.
+func (i *inode) childSkipLock(c *cred, name string) (*inode, error) {
+	if !i.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
 
-			return this, nil
-		}
+	// Searching a directory needs execute permission on it
+	if !checkPerm(c, i, 'x') {
+		return nil, syscall.EACCES
+	}
 
-		// Make sure we can read the new subdir
-		if !checkPerm(this, 'r', 'x') {
-			return nil, os.ErrPermission
+	// .. is resolved through the parent link, so it always leads to the
+	// real parent even if we got here through a symlink.  /.. is /.
+	if name == ".." {
+		if i == i.sb.root {
+			return i, nil
 		}
-
-		return this.lookup(terms[1:])
-
+		return i.parent, nil
 	}
 
-	return nil, os.ErrNotExist
+	this, ok := i.children[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return this, nil
 }
 
-// Look up a symlink as a direct child inode.  This does not
-// recurse.
-func (i *inode) lookupSymlink(name string) (*inode, error) {
-	if !i.IsDir() {
-		return nil, os.ErrInvalid
+// Resolve the target of the symlink i, which is a child of dir.  Relative
+// targets are resolved from dir, as the kernel does.
+func (i *inode) readlink(c *cred, dir *inode, links *int) (*inode, error) {
+	*links++
+	if *links > i.sb.symlinkLimit() {
+		return nil, syscall.ELOOP
 	}
 
-	l, ok := i.children[name]
-	if !ok {
-		return nil, os.ErrNotExist
+	target, _ := i.target()
+	if target == "" {
+		return nil, syscall.ENOENT
 	}
 
-	if l.mode&os.ModeSymlink == 0 || l.relName == "" || l.rel == nil {
-		return l, os.ErrInvalid
+	terms, err := parsePath(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if target[0] == '/' {
+		return i.sb.root.walk(c, terms, true, links)
 	}
 
-	return l, nil
+	return dir.walk(c, terms, true, links)
 }
 
-// Verify if the current Uid/Gid has access to the inode.
+// Verify if the credentials c have access to the inode.
 // Accepts 'r', 'w' and 'x' as permission bits to check.
-func checkPerm(i *inode, perms ...rune) bool {
-	if Uid == 0 {
+func checkPerm(c *cred, i *inode, perms ...rune) bool {
+	if c.uid == 0 {
 		// root can do anything
 		return true
 	}
 	var offset uint
 
+	i.attr.RLock()
+	uid, gid, mode := i.uid, i.gid, i.mode
+	i.attr.RUnlock()
+
+	// As on Linux, the owner class applies whenever the uid matches, even
+	// if the group or other bits would grant more access.
 	switch {
-	case i.uid == Uid:
+	case uid == c.uid:
 		offset = 0
-	case i.gid == Gid:
+	case c.inGroup(gid):
 		offset = 3
 	default:
 		offset = 6
@@ -230,17 +517,17 @@ func checkPerm(i *inode, perms ...rune) bool {
 		switch p {
 
 		case 'r':
-			if i.mode&(1<<uint(9-1-offset)) == 0 {
+			if mode&(1<<uint(9-1-offset)) == 0 {
 				return false
 			}
 
 		case 'w':
-			if i.mode&(1<<uint(9-1-offset-1)) == 0 {
+			if mode&(1<<uint(9-1-offset-1)) == 0 {
 				return false
 			}
 
 		case 'x':
-			if i.mode&(1<<uint(9-1-offset-2)) == 0 {
+			if mode&(1<<uint(9-1-offset-2)) == 0 {
 				return false
 			}
 
@@ -250,15 +537,83 @@ func checkPerm(i *inode, perms ...rune) bool {
 	return true
 }
 
+// Check whether c may remove or replace the entry for i in directory d.  In
+// a sticky directory, such as /tmp, only root and the owners of d and of i
+// may, as on Linux.
+func checkSticky(c *cred, d, i *inode) bool {
+	if c.uid == 0 {
+		return true
+	}
+
+	d.attr.RLock()
+	sticky, dirUid := d.mode&os.ModeSticky != 0, d.uid
+	d.attr.RUnlock()
+
+	if !sticky || c.uid == dirUid {
+		return true
+	}
+
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+	return c.uid == i.uid
+}
+
+// path returns the canonical absolute path of the directory i.  If i, or
+// any directory above it, has been removed the path no longer exists.  The
+// rename lock is held so that the path is not torn by a concurrent rename.
+func (i *inode) path() (string, error) {
+	i.sb.rename.Lock()
+	defer i.sb.rename.Unlock()
+
+	var elems []string
+
+	for d := i; d != d.sb.root; d = d.parent {
+		if d.parent == nil {
+			return "", syscall.ENOENT
+		}
+
+		d.parent.mu.Lock()
+		name := d.name
+		linked := d.parent.children[name] == d
+		d.parent.mu.Unlock()
+
+		if !linked {
+			return "", syscall.ENOENT
+		}
+		elems = append(elems, name)
+	}
+
+	// Reverse into root-first order
+	for l, r := 0, len(elems)-1; l < r; l, r = l+1, r-1 {
+		elems[l], elems[r] = elems[r], elems[l]
+	}
+
+	return sep + strings.Join(elems, sep), nil
+}
+
 // Find an inode by name in the filesystem
 func (t *TestFS) find(path string) (*inode, error) {
+	return t.resolve(path, true)
+}
+
+// Find an inode by name in the filesystem, without following a symlink in
+// the final element of the path.
+func (t *TestFS) lfind(path string) (*inode, error) {
+	return t.resolve(path, false)
+}
+
+func (t *TestFS) resolve(path string, follow bool) (*inode, error) {
+
+	if path == "" {
+		return nil, syscall.ENOENT
+	}
 
 	if path == "/" {
-		return &t.dirTree, nil
+		return t.dirTree, nil
 	}
 
-	if path == "" || path == "." {
-		return t.cwd, nil
+	if path == "." {
+		return t.wd(), nil
 	}
 
 	terms, err := parsePath(path)
@@ -267,9 +622,53 @@ func (t *TestFS) find(path string) (*inode, error) {
 		return nil, err
 	}
 
+	// A trailing slash always follows a symlink, and only names a
+	// directory
+	slash := trailingSlash(path)
+	if slash {
+		follow = true
+	}
+
+	start := t.wd()
 	if path[0] == '/' {
-		return t.dirTree.lookup(terms)
+		start = t.dirTree
+	}
+
+	i, err := start.lookup(&t.cred, terms, follow)
+	if err == nil && slash && !i.IsDir() {
+		return nil, syscall.ENOTDIR
 	}
 
-	return t.cwd.lookup(terms)
+	return i, err
+}
+
+// Report whether path ends in a slash, which means it must name a
+// directory.  The root directory does not count.
+func trailingSlash(path string) bool {
+	return len(path) > 1 && path[len(path)-1] == '/'
+}
+
+// Split a path into the directory and the final element, like path.Split,
+// but ignoring trailing slashes and returning "." rather than an empty
+// directory.  The directory has no trailing slash either, so that looking
+// it up does not insist on a directory before the caller can check.
+func splitPath(name string) (dir, file string) {
+	for trailingSlash(name) {
+		name = name[:len(name)-1]
+	}
+
+	dir, file = path.Split(name)
+	for trailingSlash(dir) {
+		dir = dir[:len(dir)-1]
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	return dir, file
+}
+
+// Report whether name is one of the special . and .. entries.
+func isDot(name string) bool {
+	return name == "." || name == ".."
 }
@@ -2,8 +2,11 @@ package testfs
 
 import (
 	"flag"
+	"math"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -12,9 +15,9 @@ var fs *TestFS
 func TestMain(m *testing.M) {
 	flag.Parse()
 
-	fs = NewTestFS(0,0)
 	Uid = 0
 	Gid = 0
+	fs = NewTestFS(0,0)
 
 	os.Exit(m.Run())
 }
@@ -83,91 +86,295 @@ func BenchmarkParsePath(b *testing.B) {
 
 func TestCheckPerm(t *testing.T) {
 
-	fs.dirTree.new("2", 100, 0, os.FileMode(0000))
-	fs.dirTree.new("3", 0, 200, os.FileMode(0000))
-	fs.dirTree.new("4", 0, 0, os.FileMode(0000))
-	fs.dirTree.new("5", 100, 0, os.FileMode(0700))
-	fs.dirTree.new("6", 0, 200, os.FileMode(0070))
-	fs.dirTree.new("7", 0, 0, os.FileMode(0007))
+	fs.dirTree.new(&fs.cred, "2", 100, 0, os.FileMode(0000))
+	fs.dirTree.new(&fs.cred, "3", 0, 200, os.FileMode(0000))
+	fs.dirTree.new(&fs.cred, "4", 0, 0, os.FileMode(0000))
+	fs.dirTree.new(&fs.cred, "5", 100, 0, os.FileMode(0700))
+	fs.dirTree.new(&fs.cred, "6", 0, 200, os.FileMode(0070))
+	fs.dirTree.new(&fs.cred, "7", 0, 0, os.FileMode(0007))
 
-	Uid = 100
-	Gid = 200
+	user := fs.As(100, 200)
 
 	// Check failures
-	i, err := fs.find("/2")
+	_, err := user.Open("/2")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/2")
+	_, err = user.Open("/2")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/2")
+	_, err = user.Open("/2")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/3")
+	_, err = user.Open("/3")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/3")
+	_, err = user.Open("/3")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/3")
+	_, err = user.Open("/3")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/4")
+	_, err = user.Open("/4")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/4")
+	_, err = user.Open("/4")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
-	i, err = fs.find("/4")
+	_, err = user.Open("/4")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
 
 	// Check success
-	i, err = fs.find("/5")
+	i, err := user.find("/5")
 	if err != nil {
 		t.Error(err)
 	}
-	if !checkPerm(i, 'r', 'w', 'x') {
+	if !checkPerm(&user.cred, i, 'r', 'w', 'x') {
 		t.Error("Permission check failed")
 	}
-	i, err = fs.find("/6")
+	i, err = user.find("/6")
 	if err != nil {
 		t.Error(err)
 	}
-	if !checkPerm(i, 'r', 'w', 'x') {
+	if !checkPerm(&user.cred, i, 'r', 'w', 'x') {
 		t.Error("Permission check failed")
 	}
-	i, err = fs.find("/7")
+	i, err = user.find("/7")
 	if err != nil {
 		t.Error(err)
 	}
-	if !checkPerm(i, 'r', 'w', 'x') {
+	if !checkPerm(&user.cred, i, 'r', 'w', 'x') {
 		t.Error("Permission check failed")
 	}
+}
 
-	Uid = 0
-	Gid = 0
+func TestCheckPermGroups(t *testing.T) {
+	fs.dirTree.new(&fs.cred, "groups1", 0, 300, os.FileMode(0070))
+	fs.dirTree.new(&fs.cred, "groups2", 0, 400, os.FileMode(0070))
+	fs.dirTree.new(&fs.cred, "groups3", 100, 300, os.FileMode(0070))
+	fs.dirTree.new(&fs.cred, "groups4", 0, 500, os.FileMode(0007))
+
+	user := fs.As(100, 200, 300, 400)
+
+	// Supplementary groups get group permissions
+	f, err := user.Open("/groups1")
+	if err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+	f, err = user.Open("/groups2")
+	if err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+
+	// The owner class takes precedence over the group class
+	_, err = user.Open("/groups3")
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	// Users in none of the groups get other permissions
+	f, err = user.Open("/groups4")
+	if err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+	_, err = fs.As(100, 200).Open("/groups1")
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestAs(t *testing.T) {
+	err := fs.Mkdir("/testas", os.FileMode(0777))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chmod("/testas", os.FileMode(0777))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := fs.As(100, 100)
+	bob := fs.As(200, 200)
+
+	err = alice.Mkdir("/testas/alice", os.FileMode(0700))
+	if err != nil {
+		t.Error(err)
+	}
+
+	i, err := fs.find("/testas/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.uid != 100 || i.gid != 100 {
+		t.Error("Bad ownership")
+	}
+
+	_, err = bob.Open("/testas/alice")
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	err = bob.Mkdir("/testas/alice/bob", os.FileMode(0700))
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	// The original handle is unaffected
+	if fs.cred.uid != 0 || fs.cred.gid != 0 {
+		t.Error("Bad credentials")
+	}
+
+	err = alice.Chdir("/testas")
+	if err != nil {
+		t.Error(err)
+	}
+	if fs.cwd == alice.cwd {
+		t.Error("Working directory shared between handles")
+	}
+}
+
+func TestLargeIDs(t *testing.T) {
+	tfs := NewTestFS(100000, 100000)
+	if tfs.dirTree.uid != 100000 || tfs.dirTree.gid != 100000 {
+		t.Error("Bad root ownership", tfs.dirTree.uid, tfs.dirTree.gid)
+	}
+
+	tfs.dirTree.new(&tfs.cred, "file", 165536, 200000, os.FileMode(0060))
+
+	// Ids which differ only above 16 bits are different users
+	if _, err := tfs.As(100000, 100000).Open("/file"); !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+	if _, err := tfs.As(100000, 100000, 200000).Open("/file"); err != nil {
+		t.Error(err)
+	}
+
+	fi, err := tfs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*Stat_t); st.Uid != 165536 || st.Gid != 200000 {
+		t.Error("Bad ownership", st.Uid, st.Gid)
+	}
 
+	// Ids which do not fit are rejected rather than truncated
+	reserved := uint32(math.MaxUint32)
+	for _, fn := range []func(){
+		func() { NewTestFS(-1, 0) },
+		func() { NewTestFS(0, int(reserved)) },
+		func() { tfs.As(-2, 0) },
+		func() { tfs.As(0, 0, 100, int(reserved)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Bad id accepted")
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestUmask(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+
+	checkMode := func(name string, want os.FileMode) {
+		t.Helper()
+		fi, err := tfs.Lstat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s: got mode %v, want %v", name, fi.Mode().Perm(), want)
+		}
+	}
+
+	f, err := tfs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkMode("/file", 0644)
+
+	if err := tfs.Mkdir("/dir", 0777); err != nil {
+		t.Fatal(err)
+	}
+	checkMode("/dir", 0755)
+
+	old := tfs.Umask(077)
+	if old != 022 {
+		t.Errorf("Bad old umask %v", old)
+	}
+
+	// A handle starts with the umask of its parent, but keeps its own
+	other := tfs.As(0, 0)
+	tfs.Umask(0)
+
+	f, err = other.OpenFile("/private", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkMode("/private", 0600)
+
+	if err := other.MkdirAll("/a/b", 0777); err != nil {
+		t.Fatal(err)
+	}
+	checkMode("/a", 0700)
+	checkMode("/a/b", 0700)
+
+	// Only creating a file applies the umask
+	f, err = other.OpenFile("/file", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkMode("/file", 0644)
+
+	if err := other.Symlink("/file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	checkMode("/link", 0777)
+
+	if err := tfs.Mkdir("/open", 0777); err != nil {
+		t.Fatal(err)
+	}
+	checkMode("/open", 0777)
+
+	// Only the permission bits are kept
+	if old := tfs.Umask(os.ModeDir | 0777); old != 0 {
+		t.Errorf("Bad old umask %v", old)
+	}
+	if old := tfs.Umask(0); old != 0777 {
+		t.Errorf("Bad old umask %v", old)
+	}
 }
 
 func BenchmarkCheckPerm(b *testing.B) {
 	fs = NewTestFS(0,0)
-	err := fs.dirTree.new("benchcheckperm", Uid, Gid, os.FileMode(0644))
+	err := fs.dirTree.new(&fs.cred, "benchcheckperm", fs.cred.uid, fs.cred.gid, os.FileMode(0644))
 	if err != nil {
 		b.Error(err)
 	}
 
 	for n := 0; n < b.N; n++ {
-		if !checkPerm(fs.dirTree.children["benchcheckperm"], 'r', 'w') {
+		if !checkPerm(&fs.cred, fs.dirTree.children["benchcheckperm"], 'r', 'w') {
 			b.Error("Permission check failed")
 		}
 	}
@@ -201,6 +408,83 @@ func TestFind(t *testing.T) {
 	}
 }
 
+func TestNewParent(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	err := testfs.Mkdir("/dir", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := testfs.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Only directories have children, and a parent to go with them
+	dir := testfs.dirTree.children["dir"]
+	if dir.children == nil || dir.parent != testfs.dirTree {
+		t.Error("Bad directory", dir.children, dir.parent)
+	}
+
+	file := dir.children["file"]
+	if file.children != nil || file.parent != nil {
+		t.Error("Bad file", file.children, file.parent)
+	}
+}
+
+func TestFindLoop(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	err := testfs.Mkdir("/dir", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testfs.Symlink("/loop2", "/loop1")
+	testfs.Symlink("loop1", "/loop2")
+	testfs.Symlink("/dir/self", "/dir/self")
+
+	_, err = testfs.find("/loop1")
+	if err != syscall.ELOOP {
+		t.Error("Bad error status", err)
+	}
+
+	// Loops reached through a directory in the middle of a path
+	_, err = testfs.find("/loop1/file")
+	if err != syscall.ELOOP {
+		t.Error("Bad error status", err)
+	}
+	_, err = testfs.find("/dir/self/file")
+	if err != syscall.ELOOP {
+		t.Error("Bad error status", err)
+	}
+
+	// Lstat does not follow the final link
+	_, err = testfs.lfind("/loop1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A chain of links is fine up to the limit, and fails beyond it
+	testfs.Symlink("/dir", "/chain0")
+	for n := 1; n <= 10; n++ {
+		testfs.Symlink("/chain"+strconv.Itoa(n-1), "/chain"+strconv.Itoa(n))
+	}
+
+	testfs.SetMaxSymlinks(11)
+	_, err = testfs.find("/chain10")
+	if err != nil {
+		t.Error(err)
+	}
+
+	testfs.SetMaxSymlinks(10)
+	_, err = testfs.find("/chain10")
+	if err != syscall.ELOOP {
+		t.Error("Bad error status", err)
+	}
+}
+
 func BenchmarkFind(b *testing.B) {
 	path := strings.Repeat("/testpath", 50)
 
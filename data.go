@@ -0,0 +1,222 @@
+package testfs
+
+import (
+	"syscall"
+)
+
+// Whence values for Seek which find the data and holes in a sparse file, as
+// for lseek(2).
+const (
+	SEEK_DATA = 3 // Seek to the next data at or after offset
+	SEEK_HOLE = 4 // Seek to the next hole at or after offset
+)
+
+// blockSize is the unit file data is allocated in.  Ranges of a file which
+// have never been written are holes, which take no memory and read as zeros,
+// as on a real filesystem.
+const blockSize = 4096
+
+// block is a single allocated block of file data.
+type block [blockSize]byte
+
+// fileData holds the contents of a regular file as a set of blocks, keyed by
+// their index in the file.  Missing blocks are holes.  Every operation costs
+// in proportion to the blocks it touches rather than to the size of the file.
+// Allocated bytes beyond the end of the file are always zero, so growing the
+// file exposes zeros.
+type fileData struct {
+	length int64
+	blocks map[int64]*block
+}
+
+// Return file data holding a copy of b.
+func newFileData(b []byte) fileData {
+	var d fileData
+	d.writeAt(b, 0)
+	return d
+}
+
+// Return the index of the block holding off, and where in it off is.
+func blockOf(off int64) (int64, int) {
+	return off / blockSize, int(off % blockSize)
+}
+
+// Return the number of blocks needed to hold n bytes.
+func blocksFor(n int64) int64 {
+	return (n + blockSize - 1) / blockSize
+}
+
+// Return the whole contents of the file.
+func (d *fileData) bytes() []byte {
+	b := make([]byte, d.length)
+	d.readAt(b, 0)
+	return b
+}
+
+// Copy the file contents starting at off into b, returning the number of
+// bytes copied.  Holes read as zeros.
+func (d *fileData) readAt(b []byte, off int64) int {
+	if off >= d.length {
+		return 0
+	}
+	if int64(len(b)) > d.length-off {
+		b = b[:d.length-off]
+	}
+
+	n := 0
+	for n < len(b) {
+		idx, start := blockOf(off + int64(n))
+
+		var m int
+		if blk := d.blocks[idx]; blk != nil {
+			m = copy(b[n:], blk[start:])
+		} else {
+			m = len(b) - n
+			if m > blockSize-start {
+				m = blockSize - start
+			}
+			for i := n; i < n+m; i++ {
+				b[i] = 0
+			}
+		}
+		n += m
+	}
+
+	return n
+}
+
+// Write b to the file at off, allocating any blocks it lands in.  Writing
+// past the end of the file leaves a hole in between.
+func (d *fileData) writeAt(b []byte, off int64) {
+	if len(b) == 0 {
+		return
+	}
+
+	if d.blocks == nil {
+		d.blocks = make(map[int64]*block)
+	}
+
+	n := 0
+	for n < len(b) {
+		idx, start := blockOf(off + int64(n))
+
+		blk := d.blocks[idx]
+		if blk == nil {
+			blk = new(block)
+			d.blocks[idx] = blk
+		}
+		n += copy(blk[start:], b[n:])
+	}
+
+	if end := off + int64(len(b)); end > d.length {
+		d.length = end
+	}
+}
+
+// Set the length of the file.  Growing leaves a hole, and shrinking frees
+// any blocks past the new end.
+func (d *fileData) truncate(size int64) {
+	if size >= d.length {
+		d.length = size
+		return
+	}
+
+	// Free whole blocks past the end, looking at whichever of the map and
+	// the range being freed is smaller.
+	first, last := blocksFor(size), blocksFor(d.length)
+	if int64(len(d.blocks)) < last-first {
+		for idx := range d.blocks {
+			if idx >= first {
+				delete(d.blocks, idx)
+			}
+		}
+	} else {
+		for idx := first; idx < last; idx++ {
+			delete(d.blocks, idx)
+		}
+	}
+
+	// Keep the bytes past the end of the file zero
+	idx, start := blockOf(size)
+	if blk := d.blocks[idx]; blk != nil && start > 0 {
+		tail := blk[start:]
+		for i := range tail {
+			tail[i] = 0
+		}
+	}
+
+	d.length = size
+}
+
+// Return the space allocated to the file in 512 byte units, as st_blocks.
+func (d *fileData) allocated() int64 {
+	return int64(len(d.blocks)) * blockSize / 512
+}
+
+// Return the index of the first allocated block at or after idx, and
+// whether there is one within the file.
+func (d *fileData) nextData(idx int64) (int64, bool) {
+	last := blocksFor(d.length)
+
+	// Step through the hole, unless there are fewer blocks to look at
+	if int64(len(d.blocks)) < last-idx {
+		next, ok := last, false
+		for i := range d.blocks {
+			if i >= idx && i < next {
+				next, ok = i, true
+			}
+		}
+		return next, ok
+	}
+
+	for ; idx < last; idx++ {
+		if d.blocks[idx] != nil {
+			return idx, true
+		}
+	}
+	return last, false
+}
+
+// Return the first offset at or after off which holds data, as lseek(2)
+// does for SEEK_DATA.
+func (d *fileData) seekData(off int64) (int64, error) {
+	if off < 0 || off >= d.length {
+		return 0, syscall.ENXIO
+	}
+
+	idx, _ := blockOf(off)
+	next, ok := d.nextData(idx)
+	switch {
+
+	case !ok:
+		return 0, syscall.ENXIO
+
+	case next > idx:
+		return next * blockSize, nil
+
+	}
+
+	return off, nil
+}
+
+// Return the first offset at or after off which is in a hole, as lseek(2)
+// does for SEEK_HOLE.  There is always a hole at the end of the file.
+func (d *fileData) seekHole(off int64) (int64, error) {
+	if off < 0 || off >= d.length {
+		return 0, syscall.ENXIO
+	}
+
+	idx, _ := blockOf(off)
+	if d.blocks[idx] == nil {
+		return off, nil
+	}
+
+	for d.blocks[idx] != nil {
+		idx++
+	}
+
+	if idx*blockSize > d.length {
+		return d.length, nil
+	}
+	return idx * blockSize, nil
+}
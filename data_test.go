@@ -0,0 +1,150 @@
+package testfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFileDataHoles(t *testing.T) {
+	var d fileData
+
+	d.writeAt([]byte("data"), 3*blockSize+10)
+
+	if d.length != 3*blockSize+14 {
+		t.Error("Bad length", d.length)
+	}
+	if d.allocated() != blockSize/512 {
+		t.Error("Bad block count", d.allocated())
+	}
+
+	buf := make([]byte, 20)
+	n := d.readAt(buf, 3*blockSize)
+	if n != 14 || !bytes.Equal(buf[:n], append(make([]byte, 10), "data"...)) {
+		t.Error("Bad data", buf[:n])
+	}
+
+	// A write across a block boundary allocates both blocks
+	d.writeAt([]byte("start"), blockSize-2)
+	if len(d.blocks) != 3 {
+		t.Error("Bad block count", len(d.blocks))
+	}
+
+	n = d.readAt(buf, blockSize-4)
+	if n != 20 || !bytes.Equal(buf[:8], []byte("\x00\x00start\x00")) {
+		t.Error("Bad data", buf[:n])
+	}
+}
+
+func TestFileDataTruncate(t *testing.T) {
+	d := newFileData([]byte("test data"))
+
+	d.truncate(4)
+	d.truncate(2 * blockSize)
+
+	if d.length != 2*blockSize {
+		t.Error("Bad length", d.length)
+	}
+
+	// Growing leaves a hole, and exposes zeros rather than the old data
+	if d.allocated() != blockSize/512 {
+		t.Error("Bad block count", d.allocated())
+	}
+	if !bytes.Equal(d.bytes()[:9], []byte("test\x00\x00\x00\x00\x00")) {
+		t.Error("Bad data", d.bytes()[:9])
+	}
+
+	// Cutting off a huge sparse range only frees what is allocated
+	d.writeAt([]byte("data"), 1<<40)
+	d.truncate(blockSize + 1)
+	if d.length != blockSize+1 || len(d.blocks) != 1 {
+		t.Error("Bad truncate", d.length, len(d.blocks))
+	}
+
+	d.truncate(0)
+	if d.length != 0 || len(d.blocks) != 0 {
+		t.Error("Data not freed")
+	}
+}
+
+func TestFileDataSeek(t *testing.T) {
+	var d fileData
+
+	d.writeAt([]byte("data"), blockSize)
+	d.truncate(4 * blockSize)
+
+	tests := []struct {
+		hole bool
+		off  int64
+		want int64
+		err  error
+	}{
+		{false, 0, blockSize, nil},
+		{false, blockSize + 1, blockSize + 1, nil},
+		{false, 2 * blockSize, 0, syscall.ENXIO},
+		{false, 4 * blockSize, 0, syscall.ENXIO},
+		{false, -1, 0, syscall.ENXIO},
+		{true, 0, 0, nil},
+		{true, blockSize, 2 * blockSize, nil},
+		{true, 3 * blockSize, 3 * blockSize, nil},
+		{true, 4 * blockSize, 0, syscall.ENXIO},
+	}
+
+	for _, test := range tests {
+		seek := d.seekData
+		if test.hole {
+			seek = d.seekHole
+		}
+
+		off, err := seek(test.off)
+		if off != test.want || err != test.err {
+			t.Error("Bad seek from", test.off, "hole", test.hole, "got", off, err)
+		}
+	}
+}
+
+func TestSparseFile(t *testing.T) {
+	f, err := fs.OpenFile("/testSparseFile", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Seek(1<<30, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 1<<30+4 {
+		t.Error("Bad size", fi.Size())
+	}
+	if fi.Sys().(*Stat_t).Blocks != blockSize/512 {
+		t.Error("Bad block count", fi.Sys().(*Stat_t).Blocks)
+	}
+
+	off, err := f.Seek(0, SEEK_DATA)
+	if err != nil || off != 1<<30 {
+		t.Error("Bad SEEK_DATA", off, err)
+	}
+
+	off, err = f.Seek(0, SEEK_HOLE)
+	if err != nil || off != 0 {
+		t.Error("Bad SEEK_HOLE", off, err)
+	}
+
+	_, err = f.Seek(1<<31, SEEK_DATA)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ENXIO {
+		t.Error("Bad error status", err)
+	}
+}
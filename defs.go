@@ -1,7 +1,9 @@
 package testfs
 
 import (
+	"context"
 	"os"
+	"time"
 )
 
 // Filesystem defines the basic operation of a filesystem.  It provides
@@ -10,6 +12,8 @@ type FileSystem interface {
 	Chdir(dir string) error
 	Chmod(name string, mode os.FileMode) error
 	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Lchown(name string, uid, gid int) error
 	Link(oldname, newname string) error
 	Getwd() (dir string, err error)
 	Mkdir(name string, perm os.FileMode) error
@@ -25,9 +29,19 @@ type FileSystem interface {
 	OpenFile(name string, flag int, perm os.FileMode) (file File, err error)
 	Lstat(path string) (os.FileInfo, error)
 	Stat(path string) (os.FileInfo, error)
+	Getxattr(name, attr string) ([]byte, error)
+	Lgetxattr(name, attr string) ([]byte, error)
+	Setxattr(name, attr string, data []byte, flags int) error
+	Lsetxattr(name, attr string, data []byte, flags int) error
+	Listxattr(name string) ([]string, error)
+	Llistxattr(name string) ([]string, error)
+	Removexattr(name, attr string) error
+	Lremovexattr(name, attr string) error
 }
 
-// File is analogous to os.File, providing the same functions.
+// File is analogous to os.File, providing the same functions, plus
+// extended attribute access and advisory locking.  A lock which has to wait
+// gives up when ctx is done.
 type File interface {
 	Chdir() error
 	Chmod(mode os.FileMode) error
@@ -46,4 +60,10 @@ type File interface {
 	Write(b []byte) (n int, err error)
 	WriteAt(b []byte, off int64) (n int, err error)
 	WriteString(s string) (ret int, err error)
+	Getxattr(attr string) ([]byte, error)
+	Setxattr(attr string, data []byte, flags int) error
+	Listxattr() ([]string, error)
+	Removexattr(attr string) error
+	Flock(ctx context.Context, how int) error
+	FcntlFlock(ctx context.Context, cmd int, lk *Flock_t) error
 }
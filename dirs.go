@@ -2,115 +2,94 @@ package testfs
 
 import (
 	"os"
-	"path"
+	"syscall"
 )
 
 func (t *TestFS) Mkdir(name string, perm os.FileMode) error {
-	// Ensure the dir mode is set
-	perm |= os.ModeDir
+	// Ensure the dir mode is set.  As with mkdir(2), only the sticky bit
+	// may be given along with the permissions.
+	perm = t.cred.applyUmask(perm&(os.ModePerm|os.ModeSticky)) | os.ModeDir
 
-	dir, err := t.find(path.Dir(name))
-	if err != nil {
-		return err
+	if name == "" {
+		return pathError("mkdir", name, syscall.ENOENT)
 	}
 
-	if !dir.IsDir() {
-		return os.ErrInvalid
+	parent, file := splitPath(name)
+
+	dir, err := t.find(parent)
+	if err != nil {
+		return pathError("mkdir", name, err)
 	}
 
 	// Create the directory
-	return dir.new(path.Base(name), Uid, Gid, perm)
+	return pathError("mkdir", name, dir.new(&t.cred, file, t.cred.uid, t.cred.gid, perm))
 }
 
+// MkdirAll follows the same steps as os.MkdirAll, so that it fails in the
+// same way when part of the path is missing or is not a directory.
 func (t *TestFS) MkdirAll(name string, perm os.FileMode) error {
-	// Ensure the dir mode is set
-	perm |= os.ModeDir
-
-	terms, err := parsePath(name)
-	if err != nil {
-		return err
+	// Stop early if name already exists
+	if fi, err := t.Stat(name); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return pathError("mkdir", name, syscall.ENOTDIR)
 	}
 
-	var dir *inode
-
-	if name[0] == '/' {
-		dir = &t.dirTree
-	} else {
-		dir = t.cwd
+	// Make sure the parent exists
+	i := len(name) - 1
+	for i >= 0 && name[i] == '/' {
+		i--
 	}
-
-	return dir.mkdirAll(terms, perm)
-}
-
-func (i *inode) mkdirAll(terms []string, perm os.FileMode) error {
-	if len(terms) == 0 {
-		return nil
+	for i >= 0 && name[i] != '/' {
+		i--
 	}
-
-	err := i.new(terms[0], Uid, Gid, perm)
-	if len(terms) == 1 {
-		if os.IsExist(err) {
-			return nil
+	if i > 0 {
+		if err := t.MkdirAll(name[:i], perm); err != nil {
+			return err
 		}
-		return err
 	}
 
-	dir := i.children[terms[0]]
-
-	switch {
-
-	case err == nil:
-		return dir.mkdirAll(terms[1:], perm)
-
-	case os.IsExist(err):
-		// If the child is not a directory, fail
-		if !i.children[terms[0]].IsDir() {
-			return err
+	err := t.Mkdir(name, perm)
+	if err != nil {
+		// Someone else may have got there first, or name may end in "."
+		if fi, lerr := t.Lstat(name); lerr == nil && fi.IsDir() {
+			return nil
 		}
-		// If it is a directory, just continue
-		return dir.mkdirAll(terms[1:], perm)
-
-	default:
-		// Some other error
 		return err
-
 	}
 
+	return nil
 }
 
 func (t *TestFS) Chdir(dir string) error {
 
 	d, err := t.find(dir)
 	if err != nil {
-		return err
+		return pathError("chdir", dir, err)
 	}
 
 	if !d.IsDir() {
-		return os.ErrInvalid
+		return pathError("chdir", dir, syscall.ENOTDIR)
 	}
 
-	if !checkPerm(d, 'r', 'x') {
-		return os.ErrPermission
+	if !checkPerm(&t.cred, d, 'x') {
+		return pathError("chdir", dir, syscall.EACCES)
 	}
 
+	t.cwdMu.Lock()
 	t.cwd = d
-
-	switch {
-
-	case dir[0] == '/':
-		t.cwdPath = dir
-
-	case t.cwdPath[len(t.cwdPath)-1] == '/':
-		t.cwdPath = t.cwdPath + dir
-
-	default:
-		t.cwdPath = t.cwdPath + "/" + dir
-
-	}
+	t.cwdMu.Unlock()
 
 	return nil
 }
 
+// Getwd returns the canonical path of the working directory, which is
+// worked out from the directory tree each time so that it follows renames.
 func (t *TestFS) Getwd() (dir string, err error) {
-	return t.cwdPath, nil
+	dir, err = t.wd().path()
+	if err != nil {
+		return "", os.NewSyscallError("getwd", err)
+	}
+	return dir, nil
 }
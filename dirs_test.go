@@ -5,6 +5,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 )
 
@@ -20,6 +22,60 @@ func TestMkdir(t *testing.T) {
 	}
 }
 
+func TestMkdirSetgid(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	alice := tfs.As(100, 100)
+
+	tfs.Mkdir("/shared", os.FileMode(0777))
+	tfs.Chown("/shared", 0, 500)
+	tfs.Chmod("/shared", os.FileMode(0777)|os.ModeSetgid)
+
+	// Children take the group of the directory, and subdirectories the
+	// setgid bit as well
+	err := alice.Mkdir("/shared/dir", os.FileMode(0755)|os.ModeSetuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := tfs.find("/shared/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.uid != 100 || in.gid != 500 {
+		t.Error("Bad ownership", in.uid, in.gid)
+	}
+	if in.mode != os.FileMode(0755)|os.ModeDir|os.ModeSetgid {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	// A file keeps setgid only if its creator is in the group
+	_, err = alice.OpenFile("/shared/dir/file", os.O_RDWR|os.O_CREATE, os.FileMode(0755)|os.ModeSetgid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err = tfs.find("/shared/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.gid != 500 || in.mode != os.FileMode(0755) {
+		t.Error("Bad file", in.gid, in.mode)
+	}
+
+	_, err = tfs.As(100, 100, 500).OpenFile("/shared/file", os.O_RDWR|os.O_CREATE, os.FileMode(0755)|os.ModeSetgid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err = tfs.find("/shared/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.mode != os.FileMode(0755)|os.ModeSetgid {
+		t.Error("Bad file mode", in.mode)
+	}
+}
+
 func BenchmarkMkdir(b *testing.B) {
 	fs = NewTestFS(0,0)
 	for n := 0; n < b.N; n++ {
@@ -104,8 +160,8 @@ func TestGetwd(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if dir != fs.cwdPath {
-		t.Error("Bad WD")
+	if dir != "/testchdir/test" {
+		t.Error("Bad WD", dir)
 	}
 
 	err = fs.MkdirAll("/testgetwd/test", os.FileMode(0777))
@@ -140,3 +196,204 @@ func TestGetwd(t *testing.T) {
 		t.Error("Bad WD", dir)
 	}
 }
+
+func TestChdirDotDot(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	err := testfs.MkdirAll("/a/b", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testfs.MkdirAll("/c/d", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testfs.Chdir("/a/../c")
+	if err != nil {
+		t.Error(err)
+	}
+	dir, err := testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/c" {
+		t.Error("Bad WD", dir)
+	}
+
+	err = testfs.Chdir("d/../../a/./b")
+	if err != nil {
+		t.Error(err)
+	}
+	dir, err = testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/a/b" {
+		t.Error("Bad WD", dir)
+	}
+
+	// /.. is /
+	err = testfs.Chdir("/../../a")
+	if err != nil {
+		t.Error(err)
+	}
+	dir, err = testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/a" {
+		t.Error("Bad WD", dir)
+	}
+
+	err = testfs.Chdir("../../..")
+	if err != nil {
+		t.Error(err)
+	}
+	dir, err = testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/" {
+		t.Error("Bad WD", dir)
+	}
+
+	// .. after a symlink is the parent of the target, not of the link
+	err = testfs.Symlink("/c/d", "/a/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testfs.Chdir("/a/link/..")
+	if err != nil {
+		t.Error(err)
+	}
+	dir, err = testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/c" {
+		t.Error("Bad WD", dir)
+	}
+}
+
+func TestChdirPerm(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+	user := testfs.As(100, 100)
+
+	for _, d := range []struct {
+		name string
+		mode os.FileMode
+	}{{"/x", 0711}, {"/r", 0744}} {
+		if err := testfs.Mkdir(d.name, d.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Search access alone is enough, as on Linux
+	if err := user.Chdir("/x"); err != nil {
+		t.Error(err)
+	}
+
+	err := user.Chdir("/r")
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestGetwdRename(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	err := testfs.MkdirAll("/a/b/c", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testfs.Mkdir("/x", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testfs.Chdir("/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testfs.Rename("/a/b", "/x/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/x/y/c" {
+		t.Error("Bad WD", dir)
+	}
+
+	err = testfs.Chdir("../..")
+	if err != nil {
+		t.Error(err)
+	}
+	dir, err = testfs.Getwd()
+	if err != nil {
+		t.Error(err)
+	}
+	if dir != "/x" {
+		t.Error("Bad WD", dir)
+	}
+
+	// A directory cannot be moved below itself
+	err = testfs.Rename("/x", "/x/y/c/x")
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EINVAL {
+		t.Error("Bad error status", err)
+	}
+
+	// The working directory is gone once it is removed
+	err = testfs.Chdir("/x/y/c")
+	if err != nil {
+		t.Error(err)
+	}
+	err = testfs.RemoveAll("/x/y")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testfs.Getwd()
+	if !os.IsNotExist(err) {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestChdirConcurrent(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	for _, d := range []string{"/a/b", "/c/b"} {
+		if err := testfs.MkdirAll(d, os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := testfs.Chdir("/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// One handle may change directory while another goroutine uses it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 1000; n++ {
+			if err := testfs.Chdir([]string{"/a", "/c"}[n%2]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < 1000; n++ {
+		if _, err := testfs.Stat("b"); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,49 @@
+package testfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Limits on the length of names, as on Linux.
+const (
+	nameMax = 255  // Longest single path element
+	pathMax = 4096 // Longest path, including the terminating NUL
+)
+
+var errNegativeOffset = errors.New("negative offset")
+
+// errWriteAtInAppendMode matches the error os gives for WriteAt on a file
+// opened with O_APPEND.
+var errWriteAtInAppendMode = errors.New("os: invalid use of WriteAt on file opened with O_APPEND")
+
+// errFileClosing is the error os gives when listing a closed directory.  It
+// comes from internal/poll rather than being os.ErrClosed.
+var errFileClosing = errors.New("use of closed file")
+
+// pathError wraps err in an *os.PathError, as the os package does.  A nil
+// err is returned as nil.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// linkError wraps err in an *os.LinkError, as the os package does for
+// operations on two paths.  A nil err is returned as nil.
+func linkError(op, oldname, newname string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+}
+
+// Check a new directory entry name is valid.
+func checkName(name string) error {
+	if len(name) > nameMax {
+		return syscall.ENAMETOOLONG
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package testfs
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestPathErrors(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	err := testfs.Mkdir("/dir", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := testfs.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = testfs.OpenFile("/dir/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		op    string
+		path  string
+		errno syscall.Errno
+		fn    func() error
+	}{
+		{"open", "/missing", syscall.ENOENT, func() error {
+			_, err := testfs.Open("/missing")
+			return err
+		}},
+		{"open", "/dir", syscall.EISDIR, func() error {
+			_, err := testfs.OpenFile("/dir", os.O_RDWR, 0)
+			return err
+		}},
+		{"mkdir", "/dir/file/sub", syscall.ENOTDIR, func() error {
+			return testfs.Mkdir("/dir/file/sub", os.FileMode(0755))
+		}},
+		{"mkdir", "/dir", syscall.EEXIST, func() error {
+			return testfs.Mkdir("/dir", os.FileMode(0755))
+		}},
+		{"remove", "/dir", syscall.ENOTEMPTY, func() error {
+			return testfs.Remove("/dir")
+		}},
+		{"stat", "/" + strings.Repeat("x", nameMax+1), syscall.ENAMETOOLONG, func() error {
+			_, err := testfs.Stat("/" + strings.Repeat("x", nameMax+1))
+			return err
+		}},
+		{"read", "/dir/file", syscall.EBADF, func() error {
+			_, err := f.Read(make([]byte, 1))
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		err := test.fn()
+		pe, ok := err.(*os.PathError)
+		if !ok {
+			t.Errorf("%s %s: bad error type %T", test.op, test.path, err)
+			continue
+		}
+		if pe.Op != test.op || pe.Path != test.path || pe.Err != test.errno {
+			t.Errorf("%s %s: bad error %v", test.op, test.path, err)
+		}
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+
+	err := testfs.Mkdir("/dir", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testfs.Symlink("/target", "/dir")
+	if le, ok := err.(*os.LinkError); !ok || le.Op != "symlink" || le.Err != syscall.EEXIST {
+		t.Error("Bad error status", err)
+	}
+
+	err = testfs.Link("/dir", "/link")
+	if le, ok := err.(*os.LinkError); !ok || le.Op != "link" || le.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	err = testfs.Rename("/missing", "/new")
+	if le, ok := err.(*os.LinkError); !ok || le.Op != "rename" || le.Old != "/missing" || le.Err != syscall.ENOENT {
+		t.Error("Bad error status", err)
+	}
+}
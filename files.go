@@ -7,123 +7,133 @@ import (
 	"path"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
-// Create a new file and open it.  Fail if file exists.
-func createFile(dir *inode, name string, flag int, perm os.FileMode) (*file, error) {
-	if dir == nil {
-		return nil, os.ErrInvalid
-	}
-
-	if !checkPerm(dir, 'r', 'w', 'x') {
-		return nil, os.ErrPermission
+// Create a new file and open it.  Fail if file exists.  The umask of c is
+// cleared from perm.
+func createFile(c *cred, dir *inode, name string, flag int, perm os.FileMode) (*file, error) {
+	if !dir.IsDir() {
+		return nil, syscall.ENOTDIR
 	}
 
 	dir.mu.Lock()
 	defer dir.mu.Unlock()
 
-	if _, err := dir.lookup([]string{name}); !os.IsNotExist(err) {
-		return nil, os.ErrExist
+	_, err := dir.childSkipLock(c, name)
+	switch {
+
+	case err == nil:
+		return nil, syscall.EEXIST
+
+	case !os.IsNotExist(err):
+		return nil, err
+
 	}
 
-	err := dir.newSkipLock(name, Uid, Gid, perm)
+	err = dir.newSkipLock(c, name, c.uid, c.gid, c.applyUmask(perm))
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := newFile(dir.children[name], flag), nil
-
-	return f, err
+	return newFile(c, dir.children[name], name, flag)
 }
 
 // Open an existing file.  Fail if it does not exist.
-func openFile(dir *inode, name string, flag int) (*file, error) {
-	if dir == nil {
-		return nil, os.ErrInvalid
+func openFile(c *cred, dir *inode, name string, flag int) (*file, error) {
+	if !dir.IsDir() {
+		return nil, syscall.ENOTDIR
 	}
 
-	if !checkPerm(dir, 'r', 'x') {
-		return nil, os.ErrPermission
-	}
-
-	dir.mu.Lock()
-	defer dir.mu.Unlock()
-
 	var f *inode
 	var err error
 
-    // Handle / specially
-	if name == "/" {
-		if dir.name != "/" {
-			return nil, os.ErrInvalid
-		}
+	// Handle / specially
+	if name == "" {
 		f = dir
+		name = f.Name()
 	} else {
-		f, err = dir.lookup([]string{name})
+		f, err = dir.lookup(c, []string{name}, true)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	switch {
+	var perms []rune
 
-	case flag&os.O_RDWR == os.O_RDWR:
-		if !checkPerm(f, 'r', 'w') {
-			return nil, os.ErrPermission
-		}
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
 
-	case flag&os.O_WRONLY == os.O_WRONLY:
-		if !checkPerm(f, 'w') {
-			return nil, os.ErrPermission
-		}
+	case os.O_RDWR:
+		perms = []rune{'r', 'w'}
+
+	case os.O_WRONLY:
+		perms = []rune{'w'}
 
 	default:
-		if !checkPerm(f, 'r') {
-			return nil, os.ErrPermission
-		}
+		perms = []rune{'r'}
+
 	}
 
-	return newFile(f, flag), nil
-}
+	// Truncating needs write access whatever the access mode, as on
+	// Linux.  Nothing which might change a directory may open it.
+	if flag&os.O_TRUNC == os.O_TRUNC {
+		perms = append(perms, 'w')
+	}
 
-func truncateData(data []byte, size int64) []byte {
-	s := int(size)
+	if f.IsDir() && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EISDIR
+	}
 
-	if len(data) > s {
-		// We do this to avoid memory leaks when truncating large files.
-		newData := make([]byte, s)
-		copy(newData, data[:s])
-		data = newData
+	if !checkPerm(c, f, perms...) {
+		return nil, syscall.EACCES
 	}
 
-	return data
+	return newFile(c, f, name, flag)
+}
+
+// Set the size of i on behalf of c.  This takes the inode lock, so it cannot
+// interleave with a read or write.
+func (i *inode) truncate(c *cred, size int64) {
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	i.data.truncate(size)
+	i.modified(c)
+	now := i.sb.now()
+	i.mtime = now
+	i.ctime = now
 }
 
 func (t *TestFS) Truncate(name string, size int64) error {
 	f, err := t.find(name)
 	if err != nil {
-		return err
+		return pathError("truncate", name, err)
 	}
 
-	if !checkPerm(f, 'w') {
-		return os.ErrPermission
+	if f.IsDir() {
+		return pathError("truncate", name, syscall.EISDIR)
 	}
 
-	f.data = truncateData(f.data, size)
+	if size < 0 {
+		return pathError("truncate", name, syscall.EINVAL)
+	}
 
+	if !checkPerm(&t.cred, f, 'w') {
+		return pathError("truncate", name, syscall.EACCES)
+	}
+
+	f.truncate(&t.cred, size)
 	return nil
 }
 
 func (t *TestFS) Create(name string) (File, error) {
-	dir, file := path.Split(name)
-
-	d, err := t.find(dir)
+	f, err := t.openFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		return nil, err
+		return nil, pathError("open", name, err)
 	}
-	f, err := createFile(d, file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 
-	return f, err
+	return f, nil
 }
 
 func (t *TestFS) Open(name string) (File, error) {
@@ -131,64 +141,130 @@ func (t *TestFS) Open(name string) (File, error) {
 }
 
 func (t *TestFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := t.openFile(name, flag, perm)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
 
-	// Handle root dir
-	if name == "/" {
-		return openFile(&t.dirTree, name, flag)
+	return f, nil
+}
+
+func (t *TestFS) openFile(name string, flag int, perm os.FileMode) (*file, error) {
+
+	if name == "" {
+		return nil, syscall.ENOENT
 	}
 
-	dir, file := path.Split(name)
+	dir, file := splitPath(name)
 
 	d, err := t.find(dir)
 	if err != nil {
 		return nil, err
 	}
 
+	// A trailing slash names a directory, which O_CREATE can never open
+	// and which anything else must find.  Looking it up for O_CREATE still
+	// needs search access to d.
+	if trailingSlash(name) {
+		if flag&os.O_CREATE == os.O_CREATE {
+			switch {
+			case !d.IsDir():
+				return nil, syscall.ENOTDIR
+			case !checkPerm(&t.cred, d, 'x'):
+				return nil, syscall.EACCES
+			}
+			return nil, syscall.EISDIR
+		}
+		if _, err := t.find(name); err != nil {
+			return nil, err
+		}
+	}
+
 	if flag&os.O_CREATE == os.O_CREATE {
 
-		f, err := createFile(d, file, flag, perm)
+		// Creating through a dangling symlink creates its target
+		if target, ok := t.dangling(d, file); ok && flag&os.O_EXCL == 0 {
+			if !path.IsAbs(target) {
+				target = dir + "/" + target
+			}
+			f, err := t.openFile(target, flag, perm)
+			if err != nil {
+				return nil, err
+			}
+			f.name = name
+			return f, nil
+		}
 
-		switch {
+		f, err := createFile(&t.cred, d, file, flag, perm)
+		if err == nil {
+			f.name = name
+			return f, nil
+		}
+		if !os.IsExist(err) || flag&os.O_EXCL == os.O_EXCL {
+			return nil, err
+		}
 
-		case flag&os.O_EXCL == os.O_EXCL:
-			return f, err
+		// The file already exists, so open it as if O_CREATE was not
+		// given.  A new file is empty, so there is nothing to truncate.
+	}
 
-		case os.IsExist(err):
-			return openFile(d, file, flag)
+	f, err := openFile(&t.cred, d, file, flag)
+	if err != nil {
+		return nil, err
+	}
 
-		default:
-			return f, err
+	if flag&os.O_TRUNC == os.O_TRUNC {
+		f.inode.truncate(f.cred, 0)
+	}
 
-		}
+	f.name = name
+	return f, nil
+}
+
+// Return the target of name in dir if it is a symlink to nothing.
+func (t *TestFS) dangling(dir *inode, name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
 
+	l, err := dir.lookup(&t.cred, []string{name}, false)
+	if err != nil {
+		return "", false
 	}
 
-	f, err := openFile(d, file, flag)
+	target, ok := l.target()
+	if !ok {
+		return "", false
+	}
 
-	if flag&os.O_TRUNC == os.O_TRUNC {
-		err = f.Truncate(0)
-		return f, err
+	if _, err := dir.lookup(&t.cred, []string{name}, true); !os.IsNotExist(err) {
+		return "", false
 	}
 
-	return f, err
+	return target, true
 }
 
 // file is a thin layer over an inode to simulate the concept
 // of an open file.
 type file struct {
-	flag  int     // Permission bits
-	id    uintptr // Unique ID
-	inode *inode  // Reference to an inode
-	pos   int     // Read/Write position
-}
-
-// fdCtr is a counter to generate unique fd numbers.
+	flag   int        // Permission bits
+	id     uintptr    // Unique ID
+	inode  *inode     // Reference to an inode
+	name   string     // Full name passed to OpenFile
+	mu     sync.Mutex // Guards pos and dirPos
+	pos    int        // Read/Write position
+	dirPos string     // Last directory entry listed
+	cred   *cred      // Credentials of the opener
+	closed int32      // Set by Close, used atomically
+}
+
+// fdCtr is a counter to generate unique fd and pid numbers.
 type fdCtr struct {
 	sync.Mutex
 	ctr uintptr
 }
 
-// next returns the next fd number.
+// next returns the next number.
 func (f *fdCtr) next() uintptr {
 	f.Lock()
 	defer f.Unlock()
@@ -196,22 +272,43 @@ func (f *fdCtr) next() uintptr {
 	return f.ctr
 }
 
-// Open a new file
-func newFile(i *inode, flag int) *file {
+// Open a new file.  This fails if i was removed after it was looked up.
+func newFile(c *cred, i *inode, name string, flag int) (*file, error) {
+	if err := i.open(); err != nil {
+		return nil, err
+	}
+
 	f := new(file)
+	f.cred = c
 	f.inode = i
+	f.name = name
 	f.flag = flag
 	f.id = fd.next()
-	return f
+	return f, nil
 }
 
-func (f *file) writable() bool {
-	switch {
+// Check f may be used for op.  As with os.File, a nil file is invalid and
+// a closed one gives os.ErrClosed.
+func (f *file) checkValid(op string) error {
+	if f == nil {
+		return os.ErrInvalid
+	}
+	if f.isClosed() {
+		return pathError(op, f.name, os.ErrClosed)
+	}
+	return nil
+}
 
-	case f.flag&os.O_RDWR == os.O_RDWR && checkPerm(f.inode, 'w'):
-		return true
+// Report whether f has been closed.  The inode is kept after Close, so a
+// call which raced with Close and saw f open can still finish safely.
+func (f *file) isClosed() bool {
+	return atomic.LoadInt32(&f.closed) != 0
+}
+
+func (f *file) writable() bool {
+	switch f.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
 
-	case f.flag&os.O_WRONLY == os.O_WRONLY && checkPerm(f.inode, 'w'):
+	case os.O_RDWR, os.O_WRONLY:
 		return true
 
 	default:
@@ -221,12 +318,9 @@ func (f *file) writable() bool {
 }
 
 func (f *file) readable() bool {
-	switch {
-
-	case f.flag&os.O_RDWR == os.O_RDWR && checkPerm(f.inode, 'r'):
-		return true
+	switch f.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
 
-	case f.flag&os.O_RDONLY == os.O_RDONLY && checkPerm(f.inode, 'r'):
+	case os.O_RDWR, os.O_RDONLY:
 		return true
 
 	default:
@@ -235,93 +329,77 @@ func (f *file) readable() bool {
 	}
 }
 
-// Read into b from file starting at absolute position pos.
+// Read into b from file starting at absolute position pos, as pread(2)
+// does.  The inode is locked throughout, so a read never sees part of a
+// write.  Permissions are checked when the file is opened, so only the
+// access mode matters here.
 func (f *file) read(b []byte, pos int) (n int, err error) {
-	if f == nil || f.inode == nil {
-		return 0, os.ErrInvalid
-	}
 	if !f.readable() {
-		return 0, os.ErrPermission
+		return 0, syscall.EBADF
+	}
+	if f.inode.IsDir() {
+		return 0, syscall.EISDIR
 	}
 
-	switch {
-
-	case pos >= len(f.inode.data):
-		n = 0
-		err = io.EOF
+	f.inode.attr.Lock()
+	defer f.inode.attr.Unlock()
 
-	case pos+len(b) > len(f.inode.data):
-		copy(b, f.inode.data[pos:])
-		n = len(f.inode.data) - pos
+	n = f.inode.data.readAt(b, int64(pos))
+	if n == 0 {
 		err = io.EOF
-
-	default:
-		copy(b, f.inode.data[pos:pos+len(b)])
-		n = len(b)
-
 	}
 
-	// Set the new fd position
-	f.pos = pos + n
+	f.inode.atime = f.inode.sb.now()
 
 	return
 }
 
-// Write b to file starting at absolute position pos.
-func (f *file) write(b []byte, pos int) (int, error) {
-	if f == nil || f.inode == nil {
-		return 0, os.ErrInvalid
-	}
+// Write b to file starting at absolute position pos, as pwrite(2) does.
+// If the file was opened with O_APPEND the write goes to the end of the
+// file instead, in one step so that several appending writers never
+// overwrite each other.  The offset just past the data written is returned.
+func (f *file) write(b []byte, pos int) (int, int, error) {
 	if !f.writable() {
-		return 0, os.ErrPermission
+		return 0, pos, syscall.EBADF
 	}
 
-	// We operate on a copy of the data stucture for thread safety.
-	data := f.inode.data
-
-	switch {
-
-	case pos > len(data):
-		// The data starts beyond the end of the existing file.  Zero
-		// pad the input data before appending.
-		off := pos - len(data)
-		buf := make([]byte, off+len(b))
-		copy(buf[off:], b)
-		data = append(data, buf...)
-
-	case pos == len(data):
-		data = append(data, b...)
-
-	case pos+len(b) > len(data):
-		// The data overlaps the end of the existing file.
-		data = data[:pos]
-		data = append(data, b...)
+	f.inode.attr.Lock()
+	defer f.inode.attr.Unlock()
 
-	default:
-		copy(data[pos:len(b)], b)
+	if f.flag&os.O_APPEND == os.O_APPEND {
+		pos = int(f.inode.data.length)
+	}
 
+	// Writing past the end of the file leaves a hole
+	f.inode.data.writeAt(b, int64(pos))
+	if len(b) > 0 {
+		f.inode.modified(f.cred)
 	}
+	now := f.inode.sb.now()
+	f.inode.mtime = now
+	f.inode.ctime = now
 
-	f.inode.data = data
+	// There's no non-error case where we write less than len(b)
+	return len(b), pos + len(b), nil
+}
 
-	// Set the new fd position
-	f.pos = pos + len(b)
+// Truncate the file to size bytes.
+func (f *file) truncate(size int64) error {
+	if size < 0 || !f.writable() {
+		return syscall.EINVAL
+	}
 
-	// There's no non-error case where we write less than len(b)
-	return len(b), nil
+	f.inode.truncate(f.cred, size)
+	return nil
 }
 
 // Return a sorted array of directory contents
 func (f *file) ls() ([]os.FileInfo, error) {
-	if f == nil || f.inode == nil {
-		return nil, os.ErrInvalid
+	if !f.inode.IsDir() {
+		return nil, syscall.ENOTDIR
 	}
 	if !f.readable() {
-		return nil, os.ErrPermission
-	}
-
-	if !f.inode.IsDir() {
-		return nil, os.ErrInvalid
+		return nil, syscall.EBADF
 	}
 
 	f.inode.mu.Lock()
@@ -330,18 +408,19 @@ func (f *file) ls() ([]os.FileInfo, error) {
 	var entries []string
 
 	for name := range f.inode.children {
-		if name == ".." {
-			continue
-		}
 		entries = append(entries, name)
 	}
 
 	sort.Strings(entries)
 
+	f.inode.attr.Lock()
+	f.inode.atime = f.inode.sb.now()
+	f.inode.attr.Unlock()
+
 	fi := make([]os.FileInfo, len(entries))
 
 	for i := range entries {
-		fi[i] = f.inode.children[entries[i]]
+		fi[i] = f.inode.children[entries[i]].stat(entries[i])
 	}
 
 	return fi, nil
@@ -355,173 +434,276 @@ func (f *file) ls() ([]os.FileInfo, error) {
 // given the presence of the TestFs.Chdir() function,
 // for now just return an error.
 func (f *file) Chdir() error {
+	if err := f.checkValid("chdir"); err != nil {
+		return err
+	}
+
 	return errors.New("Unsupported function")
 }
 
 func (f *file) Chmod(mode os.FileMode) error {
-
-	if f.inode == nil {
-		return os.ErrInvalid
-	}
-	if !f.writable() {
-		return os.ErrPermission
+	if err := f.checkValid("chmod"); err != nil {
+		return err
 	}
 
-	return f.inode.chmod(mode)
+	return pathError("chmod", f.name, f.inode.chmod(f.cred, mode))
 }
 
 func (f *file) Chown(uid, gid int) error {
-
-	if f.inode == nil {
-		return os.ErrInvalid
-	}
-	if !f.writable() {
-		return os.ErrPermission
+	if err := f.checkValid("chown"); err != nil {
+		return err
 	}
 
-	return f.inode.chown(uid, gid)
+	return pathError("chown", f.name, f.inode.chown(f.cred, uid, gid))
 }
 
 func (f *file) Close() error {
-	// Clear the inode reference before clearing the pointer
-	// in case some other function happens to keep a reference to it.
-	f.inode = nil
-	f = nil
+	if f == nil {
+		return os.ErrInvalid
+	}
+	if !atomic.CompareAndSwapInt32(&f.closed, 0, 1) {
+		return pathError("close", f.name, os.ErrClosed)
+	}
+
+	f.releaseLocks()
+	f.inode.close()
 	return nil
 }
 
+// Fd returns ^uintptr(0) for a closed file, as os does.
 func (f *file) Fd() uintptr {
-	if f == nil || f.inode == nil {
-		return 0
+	if f == nil || f.isClosed() {
+		return ^uintptr(0)
 	}
 
 	return f.id
 }
 
 func (f *file) Name() string {
-	if f == nil || f.inode == nil {
+	if f == nil {
 		return ""
 	}
 
-	return f.inode.name
+	return f.name
 }
 
 func (f *file) Read(b []byte) (n int, err error) {
-	return f.read(b, f.pos)
+	if err := f.checkValid("read"); err != nil {
+		return 0, err
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err = f.read(b, f.pos)
+	f.pos += n
+	if err != nil && err != io.EOF {
+		err = pathError("read", f.name, err)
+	}
+	return
 }
 
 func (f *file) ReadAt(b []byte, off int64) (n int, err error) {
-	return f.read(b, int(off))
+	if err := f.checkValid("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, pathError("readat", f.name, errNegativeOffset)
+	}
+
+	// Keep reading until b is full, as os does
+	for len(b) > 0 {
+		m, e := f.read(b, int(off))
+		if e != nil {
+			err = e
+			break
+		}
+		n += m
+		b = b[m:]
+		off += int64(m)
+	}
+
+	if err != nil && err != io.EOF {
+		err = pathError("read", f.name, err)
+	}
+	return
 }
 
 func (f *file) Readdir(n int) ([]os.FileInfo, error) {
-	entries, err := f.ls()
-	if err != nil {
-		return nil, err
+	if f == nil {
+		return nil, os.ErrInvalid
+	}
+	if f.isClosed() {
+		return nil, pathError("readdirent", f.name, errFileClosing)
 	}
 
-	if n > 0 && n < len(entries) {
-		return entries[:n], nil
+	entries, err := f.readdir(n)
+	if err != nil && err != io.EOF {
+		err = pathError("readdirent", f.name, err)
 	}
-	return entries, nil
+	return entries, err
 }
 
 func (f *file) Readdirnames(n int) (names []string, err error) {
-	entries, err := f.ls()
-	if err != nil {
-		return nil, err
+	if f == nil {
+		return nil, os.ErrInvalid
+	}
+	if f.isClosed() {
+		return nil, pathError("readdirent", f.name, errFileClosing)
 	}
 
+	entries, err := f.readdir(n)
+	if err != nil && err != io.EOF {
+		return nil, pathError("readdirent", f.name, err)
+	}
+
+	names = make([]string, len(entries))
 	for i := range entries {
+		names[i] = entries[i].Name()
+	}
+	return names, err
+}
 
-		switch {
+// Return the next n entries of the directory f, or all that are left if n
+// <= 0.  As with os, asking for n entries past the end gives io.EOF.  The
+// entries are listed in name order, so the last name listed marks how far f
+// has got, even if the directory changes in between.
+func (f *file) readdir(n int) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		case n > 0 && n <= len(names):
-			return
+	entries, err := f.ls()
+	if err != nil {
+		return nil, err
+	}
 
-		default:
-			names = append(names, entries[i].Name())
+	next := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Name() > f.dirPos
+	})
+	entries = entries[next:]
 
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(entries) {
+			entries = entries[:n]
 		}
+	}
 
+	if len(entries) > 0 {
+		f.dirPos = entries[len(entries)-1].Name()
 	}
-	return
+	return entries, nil
 }
 
 func (f *file) Seek(offset int64, whence int) (ret int64, err error) {
-	if f == nil || f.inode == nil {
-		return 0, os.ErrInvalid
+	if err := f.checkValid("seek"); err != nil {
+		return 0, err
 	}
 
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inode.attr.RLock()
+	defer f.inode.attr.RUnlock()
+
+	var pos int64
+
 	switch whence {
 
-	case 0:
-		f.pos = int(offset)
+	case io.SeekStart:
+		pos = offset
+
+	case io.SeekCurrent:
+		pos = int64(f.pos) + offset
 
-	case 1:
-		f.pos += int(offset)
+	case io.SeekEnd:
+		pos = f.inode.data.length + offset
 
-	case 2:
-		f.pos = len(f.inode.data) + int(offset)
+	case SEEK_DATA:
+		pos, err = f.inode.data.seekData(offset)
+
+	case SEEK_HOLE:
+		pos, err = f.inode.data.seekHole(offset)
 
 	default:
-		return 0, os.ErrInvalid
+		err = syscall.EINVAL
 
 	}
 
-	if f.pos > len(f.inode.data) {
-		f.pos = len(f.inode.data)
-		return int64(f.pos), io.EOF
+	if err == nil && pos < 0 {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return 0, pathError("seek", f.name, err)
 	}
 
-	return int64(f.pos), nil
+	// Seeking past the end of the file is fine.  A write there leaves a
+	// hole.  Seeking a directory back to the start lists it again.
+	f.pos = int(pos)
+	if pos == 0 {
+		f.dirPos = ""
+	}
+	return pos, nil
 }
 
 func (f *file) Stat() (fi os.FileInfo, err error) {
-	if f == nil || f.inode == nil {
-		return nil, os.ErrInvalid
-	}
-
-	if !f.readable() {
-		return nil, os.ErrPermission
+	if err := f.checkValid("stat"); err != nil {
+		return nil, err
 	}
 
-	return f.inode, nil
+	return f.inode.stat(path.Base(f.name)), nil
 }
 
 // This makes absolutely no sense in a memory-backed FS.  Don't do anything.
 func (f *file) Sync() error {
-	if f == nil || f.inode == nil {
-		return os.ErrInvalid
-	}
-	if !f.writable() {
-		return os.ErrPermission
+	if err := f.checkValid("sync"); err != nil {
+		return err
 	}
+
 	return nil
 }
 
 func (f *file) Truncate(size int64) error {
-	if f == nil || f.inode == nil {
-		return os.ErrInvalid
-	}
-	if !f.writable() {
-		return os.ErrPermission
+	if err := f.checkValid("truncate"); err != nil {
+		return err
 	}
 
-	f.inode.data = truncateData(f.inode.data, size)
-	f.pos = 0
-
-	return nil
+	return pathError("truncate", f.name, f.truncate(size))
 }
 
 func (f *file) Write(b []byte) (n int, err error) {
-	return f.write(b, f.pos)
+	if err := f.checkValid("write"); err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, f.pos, err = f.write(b, f.pos)
+	return n, pathError("write", f.name, err)
 }
 
 func (f *file) WriteAt(b []byte, off int64) (n int, err error) {
-	return f.write(b, int(off))
+	if err := f.checkValid("write"); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND == os.O_APPEND {
+		return 0, errWriteAtInAppendMode
+	}
+	if off < 0 {
+		return 0, pathError("writeat", f.name, errNegativeOffset)
+	}
+
+	n, _, err = f.write(b, int(off))
+	return n, pathError("write", f.name, err)
 }
 
 func (f *file) WriteString(s string) (ret int, err error) {
-	return f.write([]byte(s), f.pos)
+	return f.Write([]byte(s))
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
 )
 
@@ -19,7 +21,7 @@ func TestTruncate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	f.data = []byte("test data")
+	f.data = newFileData([]byte("test data"))
 
 	err = fs.Symlink("/testTruncate", "/testTruncateLink")
 	if err != nil {
@@ -31,7 +33,7 @@ func TestTruncate(t *testing.T) {
 		t.Error(err)
 	}
 
-	if bytes.Compare(f.data, []byte("test")) != 0 {
+	if bytes.Compare(f.data.bytes(), []byte("test")) != 0 {
 		t.Error("Bad data")
 	}
 
@@ -40,7 +42,7 @@ func TestTruncate(t *testing.T) {
 		t.Error(err)
 	}
 
-	if len(f.data) != 0 {
+	if f.data.length != 0 {
 		t.Error("Bad data")
 	}
 
@@ -107,6 +109,145 @@ func TestOpenFile(t *testing.T) {
 	}
 }
 
+func TestOpenFileTrunc(t *testing.T) {
+	f, err := fs.Create("/testOpenFileTrunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("test data"))
+	f.Close()
+
+	// Create truncates an existing file
+	f, err = fs.Create("/testOpenFileTrunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	i, err := fs.find("/testOpenFileTrunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.data.length != 0 {
+		t.Error("Create did not truncate")
+	}
+
+	// O_TRUNC truncates even a read-only open, but needs write permission
+	i.data = newFileData([]byte("test data"))
+	i.mode = os.FileMode(0444)
+
+	user := fs.As(500, 500)
+
+	_, err = user.OpenFile("/testOpenFileTrunc", os.O_RDONLY|os.O_TRUNC, 0)
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	i.mode = os.FileMode(0666)
+
+	f, err = user.OpenFile("/testOpenFileTrunc", os.O_RDONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if i.data.length != 0 {
+		t.Error("O_RDONLY|O_TRUNC did not truncate")
+	}
+
+	_, err = fs.OpenFile("/", os.O_RDONLY|os.O_TRUNC, 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EISDIR {
+		t.Error("Bad error status", err)
+	}
+
+	_, err = fs.OpenFile("/", os.O_RDONLY|os.O_CREATE, 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EISDIR {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestOpenFileExcl(t *testing.T) {
+	err := fs.Symlink("/testOpenFileExclMissing", "/testOpenFileExcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// O_EXCL does not follow even a dangling symlink
+	_, err = fs.OpenFile("/testOpenFileExcl", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if !os.IsExist(err) {
+		t.Error("Bad error status", err)
+	}
+
+	_, err = fs.find("/testOpenFileExclMissing")
+	if !os.IsNotExist(err) {
+		t.Error("Symlink target created")
+	}
+}
+
+func TestOpenFileAppend(t *testing.T) {
+	f, err := fs.OpenFile("/testOpenFileAppend", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, err := fs.OpenFile("/testOpenFileAppend", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// Every write goes to the end, whatever the offset
+	f.Write([]byte("one"))
+	g.Write([]byte("two"))
+	f.Seek(0, 0)
+	f.Write([]byte("three"))
+
+	i, err := fs.find("/testOpenFileAppend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(i.data.bytes()) != "onetwothree" {
+		t.Error("Bad data", string(i.data.bytes()))
+	}
+
+	pos, _ := f.Seek(0, 1)
+	if pos != 11 {
+		t.Error("Bad position after append", pos)
+	}
+
+	_, err = f.WriteAt([]byte("x"), 0)
+	if err == nil {
+		t.Error("WriteAt allowed in append mode")
+	}
+
+	// Concurrent appends never overwrite each other
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		h, err := fs.OpenFile("/testOpenFileAppend", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := 0; m < 100; m++ {
+				h.Write([]byte("x"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 811 {
+		t.Error("Lost appends, size is", fi.Size())
+	}
+}
+
 func TestFileChdir(t *testing.T) {
 	f := file{}
 	if f.Chdir().Error() != "Unsupported function" {
@@ -115,14 +256,39 @@ func TestFileChdir(t *testing.T) {
 }
 
 func TestFileChmod(t *testing.T) {
-	f, err := fs.OpenFile("/testFileChmod1", os.O_RDONLY|os.O_CREATE, os.FileMode(0664))
+	f, err := fs.Create("/testFileChmod1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	err = fs.Chown("/testFileChmod1", 100, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner may chmod through a read-only file, as on Linux
+	f, err = fs.As(100, 200).Open("/testFileChmod1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Chmod(os.FileMode(0640))
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = f.Chmod(os.FileMode(1775))
+	f.Close()
+
+	// Anyone else may not
+	f, err = fs.As(101, 200).Open("/testFileChmod1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Chmod(os.FileMode(0666))
 	if !os.IsPermission(err) {
-		t.Error("Bad error status")
+		t.Error("Bad error status", err)
 	}
 
 	f.Close()
@@ -176,15 +342,92 @@ func TestFileClose(t *testing.T) {
 		t.Error(err)
 	}
 
-	f.Close()
+	err = f.Close()
+	if err != nil {
+		t.Error(err)
+	}
 
 	err = f.Chmod(0775)
-	if err != os.ErrInvalid {
-		t.Error("Bad error status")
+	if pe, ok := err.(*os.PathError); !ok || pe.Op != "chmod" || pe.Err != os.ErrClosed {
+		t.Error("Bad error status", err)
+	}
+
+	err = f.Close()
+	if pe, ok := err.(*os.PathError); !ok || pe.Op != "close" || pe.Err != os.ErrClosed {
+		t.Error("Bad error status", err)
+	}
+
+	_, err = f.Read(nil)
+	if pe, ok := err.(*os.PathError); !ok || pe.Op != "read" || pe.Err != os.ErrClosed {
+		t.Error("Bad error status", err)
+	}
+
+	// A closed file is reported before a bad offset
+	_, err = f.ReadAt(nil, -1)
+	if pe, ok := err.(*os.PathError); !ok || pe.Op != "read" || pe.Err != os.ErrClosed {
+		t.Error("Bad error status", err)
+	}
+
+	_, err = f.WriteAt(nil, -1)
+	if pe, ok := err.(*os.PathError); !ok || pe.Op != "write" || pe.Err != os.ErrClosed {
+		t.Error("Bad error status", err)
+	}
+
+	if f.Fd() != ^uintptr(0) {
+		t.Error("Bad fd for closed file:", f.Fd())
 	}
 
 }
 
+func TestFileUnlinked(t *testing.T) {
+	f, err := fs.OpenFile("/testFileUnlinked", os.O_RDWR|os.O_CREATE, os.FileMode(0664))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := fs.Open("/testFileUnlinked")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := f.(*file).inode
+
+	err = fs.Remove("/testFileUnlinked")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The open files can still be used
+	_, err = f.Write([]byte("data"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	buf := make([]byte, 4)
+	_, err = g.ReadAt(buf, 0)
+	if err != nil || string(buf) != "data" {
+		t.Error("Bad data read from unlinked file:", string(buf), err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Sys().(*Stat_t).Nlink != 0 {
+		t.Error("Bad link count:", fi.Sys().(*Stat_t).Nlink)
+	}
+
+	f.Close()
+	if i.data.length == 0 {
+		t.Error("Data freed while still open")
+	}
+
+	g.Close()
+	if i.data.length != 0 {
+		t.Error("Data not freed on last close")
+	}
+}
+
 func TestFileFd(t *testing.T) {
 	f, err := fs.Create("/testFileFd")
 	if err != nil {
@@ -196,24 +439,40 @@ func TestFileFd(t *testing.T) {
 	}
 
 	f.Close()
-	if f.Fd() != 0 {
+	if f.Fd() != ^uintptr(0) {
 		t.Error("Bad FD")
 	}
 }
 
 func TestFileName(t *testing.T) {
-	f, err := fs.Create("/testFileName")
+	err := fs.Mkdir("/testFileNameDir", 0755)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/testFileNameDir/file")
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if f.Name() != "testFileName" {
-		t.Error("Bad name")
+	if f.Name() != "/testFileNameDir/file" {
+		t.Error("Bad name", f.Name())
 	}
 
+	fi, err := f.Stat()
+	if err != nil || fi.Name() != "file" {
+		t.Error("Bad stat name", fi, err)
+	}
+
+	// As with os, the name outlives the file
 	f.Close()
-	if f.Name() != "" {
-		t.Error("Bad name")
+	if f.Name() != "/testFileNameDir/file" {
+		t.Error("Bad name", f.Name())
+	}
+
+	err = f.Close()
+	if pe, ok := err.(*os.PathError); !ok || pe.Path != "/testFileNameDir/file" {
+		t.Error("Bad error path", err)
 	}
 }
 
@@ -225,13 +484,14 @@ func TestFileRead(t *testing.T) {
 
 	data := []byte("short test data")
 
-	f.(*file).inode.data = data
+	f.(*file).inode.data = newFileData(data)
 
 	buf := make([]byte, 20)
 
+	// A short read is not an error, as with os.  The next read finds EOF.
 	n, err := f.Read(buf)
-	if err != io.EOF {
-		t.Error("Bad error status")
+	if err != nil {
+		t.Error(err)
 	}
 	if n != 15 {
 		t.Error("Bad output len")
@@ -241,8 +501,13 @@ func TestFileRead(t *testing.T) {
 		t.Error("Bad data")
 	}
 
+	n, err = f.Read(buf)
+	if err != io.EOF || n != 0 {
+		t.Error("Bad error status")
+	}
+
 	data = []byte("long test data......................................................................................")
-	f.(*file).inode.data = data
+	f.(*file).inode.data = newFileData(data)
 	// Reset position
 	f.(*file).pos = 0
 
@@ -281,7 +546,7 @@ func TestFileReadAt(t *testing.T) {
 
 	data := []byte("short test data")
 
-	f.(*file).inode.data = data
+	f.(*file).inode.data = newFileData(data)
 
 	buf := make([]byte, 20)
 
@@ -329,15 +594,29 @@ func TestFileReaddir(t *testing.T) {
 		t.Error("Bad result content", fi[0].Name())
 	}
 
+	// The rest follow on from where the last call stopped
 	fi, err = f.Readdir(0)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if len(fi) != 4 {
-		t.Error("Bad result length")
+	if len(fi) != 2 || fi[0].Name() != "3" || fi[1].Name() != "4" {
+		t.Error("Bad result", fi)
+	}
+
+	fi, err = f.Readdir(1)
+	if err != io.EOF || len(fi) != 0 {
+		t.Error("Bad result at the end", fi, err)
 	}
 
+	// Seeking to the start lists the directory again
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Error(err)
+	}
+	fi, err = f.Readdir(-1)
+	if err != nil || len(fi) != 4 {
+		t.Error("Bad result after seek", fi, err)
+	}
 }
 
 func TestFileReaddirnames(t *testing.T) {
@@ -383,10 +662,14 @@ func TestFileReaddirnames(t *testing.T) {
 		t.Error(err)
 	}
 
-	if len(names) != 4 {
-		t.Error("Bad result length")
+	if len(names) != 2 || names[0] != "3" || names[1] != "4" {
+		t.Error("Bad result", names)
 	}
 
+	names, err = f.Readdirnames(2)
+	if err != io.EOF || len(names) != 0 {
+		t.Error("Bad result at the end", names, err)
+	}
 }
 
 func TestFileSeek(t *testing.T) {
@@ -396,16 +679,21 @@ func TestFileSeek(t *testing.T) {
 	}
 
 	data := []byte("long test data......................................................................................")
-	f.(*file).inode.data = data
+	f.(*file).inode.data = newFileData(data)
 
-	p, err := f.Seek(1, 3)
+	p, err := f.Seek(1, 5)
 	if err == nil {
 		t.Error("Bad error status")
 	}
 
+	// Seeking past the end is allowed, as with os
 	p, err = f.Seek(1, 2)
-	if err == nil {
-		t.Error("Bad error status")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if int(p) != len(data)+1 {
+		t.Error("Bad position")
 	}
 
 	p, err = f.Seek(-4, 2)
@@ -465,7 +753,7 @@ func TestFileStat(t *testing.T) {
 	}
 
 	data := []byte("short test data")
-	f.(*file).inode.data = data
+	f.(*file).inode.data = newFileData(data)
 
 	fi, err := f.Stat()
 	if err != nil {
@@ -484,18 +772,63 @@ func TestFileTruncate(t *testing.T) {
 	}
 
 	data := []byte("short test data")
-	f.(*file).inode.data = data
+	f.(*file).inode.data = newFileData(data)
 
 	err = f.Truncate(4)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if len(f.(*file).inode.data) != 4 {
+	if f.(*file).inode.data.length != 4 {
 		t.Error("Bad size")
 	}
 }
 
+func TestFileWriteSetuid(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	alice := tfs.As(100, 100)
+
+	tfs.dirTree.new(&tfs.cred, "file", 100, 100, os.FileMode(0777)|os.ModeSetuid|os.ModeSetgid)
+	in := tfs.dirTree.children["file"]
+
+	// Root may write without losing anything
+	f, err := tfs.OpenFile("/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("data"))
+	f.Close()
+
+	if in.mode != os.FileMode(0777)|os.ModeSetuid|os.ModeSetgid {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	// Anyone else clears setuid and setgid, even the owner
+	f, err = alice.OpenFile("/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write(nil)
+	if in.mode != os.FileMode(0777)|os.ModeSetuid|os.ModeSetgid {
+		t.Error("Bad file mode after empty write", in.mode)
+	}
+
+	f.Write([]byte("data"))
+	if in.mode != os.FileMode(0777) {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	in.mode = os.FileMode(0777) | os.ModeSetuid
+	if err := alice.Truncate("/file", 0); err != nil {
+		t.Error(err)
+	}
+	if in.mode != os.FileMode(0777) {
+		t.Error("Bad file mode after truncate", in.mode)
+	}
+}
+
 func TestFileWrite(t *testing.T) {
 	f, err := fs.Create("/testFileWrite")
 	if err != nil {
@@ -622,6 +955,110 @@ func TestFileWriteString(t *testing.T) {
 	}
 }
 
+// Run readers, writers and metadata changes against one file at once.  Run
+// with -race to check the locking as well as the results.
+func TestFileConcurrent(t *testing.T) {
+	f, err := fs.Create("/testFileConcurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each write covers several blocks, and must never be seen in part
+	const off, size = 1000, 3*blockSize + 100
+	f.WriteAt(bytes.Repeat([]byte("a"), size), off)
+
+	var wg sync.WaitGroup
+	run := func(fn func(n int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				fn(n)
+			}
+		}()
+	}
+
+	for w := 0; w < 4; w++ {
+		rec := bytes.Repeat([]byte{byte('b' + w)}, size)
+		run(func(n int) {
+			if _, err := f.WriteAt(rec, off); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	for r := 0; r < 4; r++ {
+		buf := make([]byte, size)
+		run(func(n int) {
+			if _, err := f.ReadAt(buf, off); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(buf, bytes.Repeat(buf[:1], size)) {
+				t.Error("Read part of a write")
+			}
+		})
+	}
+
+	// Truncating past the records leaves them alone
+	run(func(n int) {
+		if err := f.Truncate(off + size + int64(n)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	run(func(n int) {
+		if _, err := fs.Stat("/testFileConcurrent"); err != nil {
+			t.Error(err)
+		}
+		if _, err := f.Stat(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	run(func(n int) {
+		fs.Chmod("/testFileConcurrent", os.FileMode(0600|n%2*044))
+		fs.Setxattr("/testFileConcurrent", "user.test", []byte{byte(n)}, 0)
+		fs.Getxattr("/testFileConcurrent", "user.test")
+	})
+
+	g, err := fs.Open("/testFileConcurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	run(func(n int) {
+		g.Seek(0, io.SeekEnd)
+		g.Seek(0, SEEK_DATA)
+		g.Read(make([]byte, 16))
+	})
+
+	// Writes through one handle each move the shared offset once
+	h, err := fs.Create("/testFileConcurrentShared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for w := 0; w < 4; w++ {
+		run(func(n int) {
+			h.Write([]byte("0123456789"))
+		})
+	}
+
+	wg.Wait()
+
+	fi, err := h.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 4*200*10 {
+		t.Error("Lost writes, size is", fi.Size())
+	}
+}
+
 func BenchmarkRead(b *testing.B) {
 	f, _ := fs.OpenFile("/testBenchmarkRead", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
 
@@ -643,3 +1080,79 @@ func BenchmarkWrite(b *testing.B) {
 		f.WriteAt(in, 0)
 	}
 }
+
+// The benchmarks below work on files large enough that copying all of the
+// data on each operation would show.
+
+func BenchmarkAppend(b *testing.B) {
+	f, _ := fs.OpenFile("/testBenchmarkAppend", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	defer fs.Remove("/testBenchmarkAppend")
+	defer f.Close()
+
+	in := make([]byte, 64<<10)
+	b.SetBytes(int64(len(in)))
+
+	for n := 0; n < b.N; n++ {
+		// Start again at 256MB to bound memory use
+		if n%4096 == 0 {
+			f.Truncate(0)
+			f.Seek(0, 0)
+		}
+		f.Write(in)
+	}
+}
+
+func BenchmarkWriteAtLarge(b *testing.B) {
+	f, _ := fs.OpenFile("/testBenchmarkWriteAtLarge", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	defer fs.Remove("/testBenchmarkWriteAtLarge")
+	defer f.Close()
+
+	f.Truncate(256 << 20)
+
+	in := make([]byte, 4096)
+	b.SetBytes(int64(len(in)))
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.WriteAt(in, int64(n*7919%65536)*4096)
+	}
+}
+
+func BenchmarkReadAtLarge(b *testing.B) {
+	f, _ := fs.OpenFile("/testBenchmarkReadAtLarge", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	defer fs.Remove("/testBenchmarkReadAtLarge")
+	defer f.Close()
+
+	chunk := make([]byte, 1<<20)
+	for n := 0; n < 64; n++ {
+		f.Write(chunk)
+	}
+
+	out := make([]byte, 4096)
+	b.SetBytes(int64(len(out)))
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		f.ReadAt(out, int64(n*7919%16384)*4096)
+	}
+}
+
+func BenchmarkTruncateLarge(b *testing.B) {
+	f, _ := fs.OpenFile("/testBenchmarkTruncateLarge", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	defer fs.Remove("/testBenchmarkTruncateLarge")
+	defer f.Close()
+
+	chunk := make([]byte, 1<<20)
+	for n := 0; n < 64; n++ {
+		f.Write(chunk)
+	}
+
+	in := make([]byte, 4096)
+	b.ResetTimer()
+
+	// Cut a block off the end and write it back
+	for n := 0; n < b.N; n++ {
+		f.Truncate(64<<20 - 4096)
+		f.WriteAt(in, 64<<20-4096)
+	}
+}
@@ -0,0 +1,286 @@
+package fstests
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jfindley/testfs"
+)
+
+var _ = register(
+	test{name: "Mkdir", skip: ReadOnly, fn: testMkdir},
+	test{name: "MkdirErrors", skip: ReadOnly, fn: testMkdirErrors},
+	test{name: "MkdirAll", skip: ReadOnly, fn: testMkdirAll},
+	test{name: "Remove", skip: ReadOnly, fn: testRemove},
+	test{name: "RemoveAll", skip: ReadOnly, fn: testRemoveAll},
+	test{name: "Readdir", fixture: true, fn: testReaddir},
+	test{name: "Chdir", skip: NoChdir, fixture: true, fn: testChdir},
+	test{name: "FileChdir", skip: NoChdir | NoFileChdir, fixture: true, fn: testFileChdir},
+)
+
+func testMkdir(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.Mkdir(dir+"/new", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(dir + "/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.ModeDir|0700)
+	}
+	if fi.Name() != "new" {
+		t.Errorf("got name %q, want %q", fi.Name(), "new")
+	}
+}
+
+func testMkdirErrors(t *testing.T, fs testfs.FileSystem, dir string) {
+	writeFile(t, fs, dir+"/file", "", 0644)
+
+	err := fs.Mkdir(dir+"/file", 0755)
+	checkPathError(t, err, "mkdir", syscall.EEXIST)
+
+	err = fs.Mkdir(dir+"/missing/new", 0755)
+	checkPathError(t, err, "mkdir", syscall.ENOENT)
+
+	err = fs.Mkdir(dir+"/file/new", 0755)
+	checkPathError(t, err, "mkdir", syscall.ENOTDIR)
+
+	err = fs.Mkdir(dir+"/"+strings.Repeat("x", 256), 0755)
+	checkPathError(t, err, "mkdir", syscall.ENAMETOOLONG)
+}
+
+func testMkdirAll(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.MkdirAll(dir+"/a/b/c", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(dir + "/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Error("not a directory")
+	}
+
+	// Existing directories are fine
+	err = fs.MkdirAll(dir+"/a/b", 0755)
+	if err != nil {
+		t.Error(err)
+	}
+
+	writeFile(t, fs, dir+"/a/file", "", 0644)
+
+	err = fs.MkdirAll(dir+"/a/file", 0755)
+	checkPathError(t, err, "mkdir", syscall.ENOTDIR)
+
+	err = fs.MkdirAll(dir+"/a/file/sub", 0755)
+	checkPathError(t, err, "mkdir", syscall.ENOTDIR)
+}
+
+func testRemove(t *testing.T, fs testfs.FileSystem, dir string) {
+	writeFile(t, fs, dir+"/file", "data", 0644)
+
+	err := fs.Mkdir(dir+"/empty", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.MkdirAll(dir+"/full/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"file", "empty"} {
+		err = fs.Remove(dir + "/" + name)
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = fs.Lstat(dir + "/" + name)
+		checkPathError(t, err, "lstat", syscall.ENOENT)
+	}
+
+	err = fs.Remove(dir + "/full")
+	checkPathError(t, err, "remove", syscall.ENOTEMPTY)
+
+	err = fs.Remove(dir + "/missing")
+	checkPathError(t, err, "remove", syscall.ENOENT)
+}
+
+func testRemoveAll(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.MkdirAll(dir+"/tree/a/b", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, dir+"/tree/a/file", "data", 0644)
+	writeFile(t, fs, dir+"/tree/a/b/file", "data", 0644)
+
+	err = fs.RemoveAll(dir + "/tree")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = fs.Lstat(dir + "/tree")
+	checkPathError(t, err, "lstat", syscall.ENOENT)
+
+	// There is nothing to remove, which is fine
+	err = fs.RemoveAll(dir + "/tree")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func testReaddir(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.Open(dir + "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	want := []string{"empty", "file", "sub"}
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("got names %v, want %v", names, want)
+	}
+
+	g, err := fs.Open(dir + "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	fis, err := g.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(fis), len(want))
+	}
+
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	for i, fi := range fis {
+		if fi.Name() != want[i] {
+			t.Errorf("got name %q, want %q", fi.Name(), want[i])
+		}
+		if fi.IsDir() != (want[i] == "sub") {
+			t.Errorf("%s: bad mode %v", fi.Name(), fi.Mode())
+		}
+	}
+
+	// Asking for n entries at a time gives the next n until io.EOF
+	p, err := fs.Open(dir + "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var paged []string
+	for calls := 0; ; calls++ {
+		if calls > len(want) {
+			t.Fatal("Readdirnames(2) never returned io.EOF")
+		}
+
+		names, err := p.Readdirnames(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) == 0 || len(names) > 2 {
+			t.Errorf("got %d names, want 1 or 2", len(names))
+		}
+		paged = append(paged, names...)
+	}
+	sort.Strings(paged)
+	if strings.Join(paged, ",") != strings.Join(want, ",") {
+		t.Errorf("got names %v a page at a time, want %v", paged, want)
+	}
+
+	fis, err = p.Readdir(1)
+	if err != io.EOF || len(fis) != 0 {
+		t.Errorf("got %d entries and %v at the end, want io.EOF", len(fis), err)
+	}
+
+	h, err := fs.Open(dir + "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	_, err = h.Readdirnames(-1)
+	if err == nil {
+		t.Error("listed a regular file")
+	}
+}
+
+// Change to dir for the rest of the test, going back to the original
+// working directory afterwards.
+func chdir(t *testing.T, fs testfs.FileSystem, dir string) {
+	wd, err := fs.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fs.Chdir(wd)
+	})
+
+	err = fs.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testChdir(t *testing.T, fs testfs.FileSystem, dir string) {
+	chdir(t, fs, dir+"/dir")
+
+	if got := readFile(t, fs, "file"); got != "hello, world\n" {
+		t.Errorf("got %q through a relative path", got)
+	}
+
+	wd, err := fs.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, wd+"/file"); got != "hello, world\n" {
+		t.Errorf("got %q through the working directory", got)
+	}
+
+	err = fs.Chdir(dir + "/dir/file")
+	checkPathError(t, err, "chdir", syscall.ENOTDIR)
+
+	err = fs.Chdir(dir + "/missing")
+	checkPathError(t, err, "chdir", syscall.ENOENT)
+}
+
+func testFileChdir(t *testing.T, fs testfs.FileSystem, dir string) {
+	chdir(t, fs, dir)
+
+	f, err := fs.Open(dir + "/dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.Chdir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, fs, "nested"); got != "nested file" {
+		t.Errorf("got %q through a relative path", got)
+	}
+}
@@ -0,0 +1,738 @@
+package fstests
+
+import (
+	"io"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/jfindley/testfs"
+)
+
+var _ = register(
+	test{name: "ReadFixture", fixture: true, fn: testReadFixture},
+	test{name: "Open", fixture: true, fn: testOpen},
+	test{name: "OpenFile", skip: ReadOnly, fn: testOpenFile},
+	test{name: "OpenFlags", skip: ReadOnly, fn: testOpenFlags},
+	test{name: "OpenExclSymlink", skip: ReadOnly | NoSymlinks, fn: testOpenExclSymlink},
+	test{name: "Append", skip: ReadOnly, fn: testAppend},
+	test{name: "AccessMode", skip: ReadOnly, fn: testAccessMode},
+	test{name: "ReadWrite", skip: ReadOnly, fn: testReadWrite},
+	test{name: "ReadAtWriteAt", skip: ReadOnly, fn: testReadAtWriteAt},
+	test{name: "Seek", fixture: true, fn: testSeek},
+	test{name: "Sparse", skip: ReadOnly, fn: testSparse},
+	test{name: "Truncate", skip: ReadOnly, fn: testTruncate},
+	test{name: "RemoveOpen", skip: ReadOnly, fn: testRemoveOpen},
+	test{name: "FileMethods", fixture: true, fn: testFileMethods},
+	test{name: "Closed", fixture: true, fn: testClosed},
+	test{name: "TrailingSlash", skip: ReadOnly | NoHardlinks, fn: testTrailingSlash},
+)
+
+func testReadFixture(t *testing.T, fs testfs.FileSystem, dir string) {
+	for _, e := range Fixture {
+		name := dir + "/" + e.Name
+
+		fi, err := fs.Lstat(name)
+		if e.Mode&os.ModeSymlink != 0 && os.IsNotExist(err) {
+			// The filesystem may lack symlinks
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if fi.Mode().Type() != e.Mode.Type() {
+			t.Errorf("%s: got mode %v, want %v", e.Name, fi.Mode(), e.Mode)
+		}
+		if fi.Name() != path.Base(e.Name) {
+			t.Errorf("%s: got name %q", e.Name, fi.Name())
+		}
+
+		if !e.Mode.IsRegular() {
+			continue
+		}
+
+		if fi.Size() != int64(len(e.Data)) {
+			t.Errorf("%s: got size %d, want %d", e.Name, fi.Size(), len(e.Data))
+		}
+		if got := readFile(t, fs, name); got != e.Data {
+			t.Errorf("%s: got %q, want %q", e.Name, got, e.Data)
+		}
+	}
+}
+
+func testOpen(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.Open(dir + "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, err = fs.Open(dir + "/missing")
+	checkPathError(t, err, "open", syscall.ENOENT)
+
+	_, err = fs.Open(dir + "/dir/file/sub")
+	checkPathError(t, err, "open", syscall.ENOTDIR)
+
+	// Directories can be opened for reading only
+	f, err = fs.Open(dir + "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func testOpenFile(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+
+	_, err := fs.OpenFile(name, os.O_RDWR, 0)
+	checkPathError(t, err, "open", syscall.ENOENT)
+
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Mode().IsRegular() || fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.FileMode(0600))
+	}
+
+	_, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	checkPathError(t, err, "open", syscall.EEXIST)
+
+	// O_CREATE alone opens an existing file
+	f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	err = fs.Mkdir(dir+"/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.OpenFile(dir+"/sub", os.O_RDWR, 0)
+	checkPathError(t, err, "open", syscall.EISDIR)
+
+	_, err = fs.OpenFile(dir+"/sub", os.O_WRONLY, 0)
+	checkPathError(t, err, "open", syscall.EISDIR)
+}
+
+func testOpenFlags(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "data", 0644)
+
+	// Create truncates an existing file
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readFile(t, fs, name); got != "" {
+		t.Errorf("got %q after Create", got)
+	}
+
+	// O_CREATE alone leaves an existing file alone
+	writeFile(t, fs, dir+"/other", "data", 0644)
+	f, err = fs.OpenFile(dir+"/other", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readFile(t, fs, dir+"/other"); got != "data" {
+		t.Errorf("got %q after O_CREATE", got)
+	}
+
+	// Linux truncates on O_RDONLY|O_TRUNC
+	f, err = fs.OpenFile(dir+"/other", os.O_RDONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readFile(t, fs, dir+"/other"); got != "" {
+		t.Errorf("got %q after O_RDONLY|O_TRUNC", got)
+	}
+
+	err = fs.Mkdir(dir+"/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, flag := range []int{os.O_RDONLY | os.O_TRUNC, os.O_RDONLY | os.O_CREATE} {
+		_, err = fs.OpenFile(dir+"/sub", flag, 0644)
+		checkPathError(t, err, "open", syscall.EISDIR)
+	}
+
+	_, err = fs.OpenFile(dir+"/sub", os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0644)
+	checkPathError(t, err, "open", syscall.EEXIST)
+}
+
+func testOpenExclSymlink(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.Symlink("missing", dir+"/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// O_EXCL fails on any symlink, even a dangling one
+	_, err = fs.OpenFile(dir+"/link", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	checkPathError(t, err, "open", syscall.EEXIST)
+
+	_, err = fs.Lstat(dir + "/missing")
+	checkPathError(t, err, "lstat", syscall.ENOENT)
+
+	// Without it, the target is created
+	f, err := fs.OpenFile(dir+"/link", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, err = fs.Lstat(dir + "/missing")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func testAppend(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, err := fs.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// Every write goes to the end of the file, wherever the offset is
+	_, err = f.WriteString("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.WriteString("two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("three")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, fs, name); got != "onetwothree" {
+		t.Errorf("got %q, want %q", got, "onetwothree")
+	}
+
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || off != 11 {
+		t.Errorf("got offset %d, %v, want 11", off, err)
+	}
+
+	_, err = f.WriteAt([]byte("x"), 0)
+	if err == nil {
+		t.Error("WriteAt worked on a file opened with O_APPEND")
+	}
+}
+
+func testAccessMode(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "data", 0644)
+
+	r, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	_, err = r.Write([]byte("x"))
+	checkPathError(t, err, "write", syscall.EBADF)
+
+	w, err := fs.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	_, err = w.Read(make([]byte, 1))
+	checkPathError(t, err, "read", syscall.EBADF)
+
+	// A file created without write permission is still writable through
+	// the handle that created it.
+	f, err := fs.OpenFile(dir+"/readonly", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte("data"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func testReadWrite(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.Create(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n, err := f.Write([]byte("hello, "))
+	if err != nil || n != 7 {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	n, err = f.WriteString("world")
+	if err != nil || n != 5 {
+		t.Fatalf("WriteString returned %d, %v", n, err)
+	}
+
+	// The offset is at the end of the file
+	buf := make([]byte, 4)
+	n, err = f.Read(buf)
+	if n != 0 || err != io.EOF {
+		t.Errorf("Read at EOF returned %d, %v", n, err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero length read is never an error
+	n, err = f.Read(nil)
+	if n != 0 || err != nil {
+		t.Errorf("empty Read returned %d, %v", n, err)
+	}
+
+	// Short reads are not an error
+	buf = make([]byte, 20)
+	n, err = f.Read(buf)
+	if err != nil || string(buf[:n]) != "hello, world" {
+		t.Errorf("Read returned %q, %v", buf[:n], err)
+	}
+
+	// Overwrite part of the middle
+	_, err = f.Seek(7, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("there")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, fs, dir+"/file"); got != "hello, there" {
+		t.Errorf("got %q, want %q", got, "hello, there")
+	}
+}
+
+func testReadAtWriteAt(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.OpenFile(dir+"/file", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.WriteAt([]byte("ab"), 2)
+	if err != nil || n != 2 {
+		t.Errorf("WriteAt returned %d, %v", n, err)
+	}
+
+	buf := make([]byte, 4)
+	n, err = f.ReadAt(buf, 1)
+	if err != nil || string(buf[:n]) != "1ab4" {
+		t.Errorf("ReadAt returned %q, %v", buf[:n], err)
+	}
+
+	// A ReadAt which runs off the end of the file returns io.EOF
+	n, err = f.ReadAt(buf, 8)
+	if err != io.EOF || string(buf[:n]) != "89" {
+		t.Errorf("ReadAt returned %q, %v", buf[:n], err)
+	}
+
+	// Neither moves the file offset
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || off != 10 {
+		t.Errorf("got offset %d, %v, want 10", off, err)
+	}
+
+	_, err = f.ReadAt(buf, -1)
+	if err == nil {
+		t.Error("ReadAt accepted a negative offset")
+	}
+	_, err = f.WriteAt(buf, -1)
+	if err == nil {
+		t.Error("WriteAt accepted a negative offset")
+	}
+}
+
+func testSeek(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.Open(dir + "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{5, io.SeekStart, 5},
+		{2, io.SeekCurrent, 7},
+		{-3, io.SeekCurrent, 4},
+		{-1, io.SeekEnd, 12},
+		{0, io.SeekStart, 0},
+	}
+
+	for _, test := range tests {
+		off, err := f.Seek(test.offset, test.whence)
+		if err != nil || off != test.want {
+			t.Errorf("Seek(%d, %d) returned %d, %v, want %d", test.offset, test.whence, off, err, test.want)
+		}
+	}
+
+	buf := make([]byte, 5)
+	_, err = f.Seek(7, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := f.Read(buf)
+	if err != nil || string(buf[:n]) != "world" {
+		t.Errorf("Read after Seek returned %q, %v", buf[:n], err)
+	}
+
+	_, err = f.Seek(-1, io.SeekStart)
+	checkPathError(t, err, "seek", syscall.EINVAL)
+
+	_, err = f.Seek(0, 99)
+	checkPathError(t, err, "seek", syscall.EINVAL)
+}
+
+func testSparse(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.OpenFile(dir+"/file", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Seeking past the end is fine, and leaves the size alone
+	off, err := f.Seek(1<<20, io.SeekStart)
+	if err != nil || off != 1<<20 {
+		t.Fatalf("Seek returned %d, %v", off, err)
+	}
+
+	n, err := f.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Errorf("Read past the end returned %d, %v", n, err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Errorf("got size %d after seeking, want 0", fi.Size())
+	}
+
+	// A write there leaves a hole, which reads as zeros
+	_, err = f.WriteString("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 1<<20+4 {
+		t.Errorf("got size %d, want %d", fi.Size(), 1<<20+4)
+	}
+
+	buf := make([]byte, 8)
+	n, err = f.ReadAt(buf, 1<<20-4)
+	if err != nil || string(buf[:n]) != "\x00\x00\x00\x00data" {
+		t.Errorf("ReadAt returned %q, %v", buf[:n], err)
+	}
+
+	// Whether holes are reported depends on the filesystem, but there
+	// is always one at the end and no data past it.
+	off, err = f.Seek(1<<20, testfs.SEEK_HOLE)
+	if err != nil || off != 1<<20+4 {
+		t.Errorf("SEEK_HOLE returned %d, %v", off, err)
+	}
+
+	_, err = f.Seek(1<<21, testfs.SEEK_DATA)
+	checkPathError(t, err, "seek", syscall.ENXIO)
+}
+
+func testTruncate(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "0123456789", 0644)
+
+	err := fs.Truncate(name, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, name); got != "0123" {
+		t.Errorf("got %q after shrinking", got)
+	}
+
+	// Growing a file fills it with zeros
+	err = fs.Truncate(name, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, name); got != "0123\x00\x00" {
+		t.Errorf("got %q after growing", got)
+	}
+
+	err = fs.Truncate(dir+"/missing", 0)
+	checkPathError(t, err, "truncate", syscall.ENOENT)
+
+	err = fs.Mkdir(dir+"/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Truncate(dir+"/sub", 0)
+	checkPathError(t, err, "truncate", syscall.EISDIR)
+
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.Truncate(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 2 {
+		t.Errorf("got size %d, want 2", fi.Size())
+	}
+
+	r, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.Truncate(0)
+	checkPathError(t, err, "truncate", syscall.EINVAL)
+}
+
+func testRemoveOpen(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "data", 0644)
+
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = fs.Remove(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A removed file lives on until it is closed
+	_, err = f.WriteAt([]byte("more"), 4)
+	if err != nil {
+		t.Error(err)
+	}
+
+	buf := make([]byte, 8)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil || string(buf[:n]) != "datamore" {
+		t.Errorf("ReadAt returned %q, %v", buf[:n], err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := nlink(fi); ok && n != 0 {
+		t.Errorf("got %d links, want 0", n)
+	}
+
+	// The name is free for a new file
+	writeFile(t, fs, name, "new", 0644)
+	if got := readFile(t, fs, name); got != "new" {
+		t.Errorf("got %q from the new file", got)
+	}
+}
+
+func testFileMethods(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.Open(dir + "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, err := fs.Open(dir + "/dir/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	if path.Base(f.Name()) != "file" {
+		t.Errorf("got name %q", f.Name())
+	}
+
+	if f.Fd() == g.Fd() {
+		t.Error("two open files share a descriptor")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file" || fi.Size() != 13 || !fi.Mode().IsRegular() {
+		t.Errorf("got name %q, size %d and mode %v", fi.Name(), fi.Size(), fi.Mode())
+	}
+
+	err = f.Sync()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func testClosed(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, err := fs.Open(dir + "/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkClosed := func(err error, op string) {
+		t.Helper()
+
+		pe, ok := err.(*os.PathError)
+		if !ok || pe.Op != op || pe.Err != os.ErrClosed {
+			t.Errorf("got error %v, want %s: %v", err, op, os.ErrClosed)
+		}
+	}
+
+	checkClosed(f.Close(), "close")
+
+	_, err = f.Read(nil)
+	checkClosed(err, "read")
+
+	_, err = f.ReadAt(make([]byte, 1), 0)
+	checkClosed(err, "read")
+
+	_, err = f.Write(nil)
+	checkClosed(err, "write")
+
+	_, err = f.WriteAt([]byte("x"), 0)
+	checkClosed(err, "write")
+
+	_, err = f.Seek(0, io.SeekStart)
+	checkClosed(err, "seek")
+
+	_, err = f.Stat()
+	checkClosed(err, "stat")
+
+	checkClosed(f.Sync(), "sync")
+	checkClosed(f.Truncate(0), "truncate")
+
+	if f.Fd() != ^uintptr(0) {
+		t.Errorf("got descriptor %d for a closed file", f.Fd())
+	}
+	if path.Base(f.Name()) != "file" {
+		t.Errorf("got name %q for a closed file", f.Name())
+	}
+}
+
+func testTrailingSlash(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "data", 0644)
+
+	// A trailing slash only names a directory
+	_, err := fs.Stat(name + "/")
+	checkPathError(t, err, "stat", syscall.ENOTDIR)
+
+	_, err = fs.Open(name + "/")
+	checkPathError(t, err, "open", syscall.ENOTDIR)
+
+	_, err = fs.OpenFile(dir+"/new/", os.O_RDWR|os.O_CREATE, 0644)
+	checkPathError(t, err, "open", syscall.EISDIR)
+
+	err = fs.Remove(name + "/")
+	checkPathError(t, err, "remove", syscall.ENOTDIR)
+
+	err = fs.Rename(name+"/", dir+"/new")
+	checkLinkError(t, err, "rename", syscall.ENOTDIR)
+
+	err = fs.Rename(name, dir+"/new/")
+	checkLinkError(t, err, "rename", syscall.ENOTDIR)
+
+	err = fs.Link(name+"/", dir+"/new")
+	checkLinkError(t, err, "link", syscall.ENOTDIR)
+
+	err = fs.Link(name, dir+"/new/")
+	checkLinkError(t, err, "link", syscall.ENOENT)
+
+	if got := readFile(t, fs, name); got != "data" {
+		t.Errorf("got %q, want %q", got, "data")
+	}
+
+	// Directories are fine
+	err = fs.Mkdir(dir+"/sub/", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(dir + "/sub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("got mode %v, want a directory", fi.Mode())
+	}
+
+	err = fs.Rename(dir+"/sub/", dir+"/moved/")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Remove(dir + "/moved/")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package fstests is a conformance suite for implementations of
+// testfs.FileSystem.  It checks that an implementation behaves as the os
+// package does on Linux, which is the behaviour TestFS targets.
+//
+// A typical use is:
+//
+//	func TestConformance(t *testing.T) {
+//		fstests.Run(t, func(t *testing.T) (testfs.FileSystem, string) {
+//			return mypkg.New(), "/"
+//		}, fstests.NoSymlinks|fstests.NoChown)
+//	}
+package fstests
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jfindley/testfs"
+)
+
+// Factory returns a new filesystem for a single test, and the absolute path
+// of the directory in it which the test may use.  The directory must be empty
+// unless the filesystem is ReadOnly, in which case it must hold Fixture.  Any
+// cleanup should be registered with t.Cleanup.
+type Factory func(t *testing.T) (fs testfs.FileSystem, dir string)
+
+// Flags declare features a filesystem lacks.  Tests which need them are
+// skipped.
+type Flags uint
+
+const (
+	NoSymlinks  Flags = 1 << iota // Symlink, Readlink and Lchown
+	NoHardlinks                   // Link
+	NoChmod                       // Chmod on names and files
+	NoChown                       // Chown and Lchown on names and files
+	NoChtimes                     // Chtimes
+	NoXattrs                      // Extended attributes
+	NoChdir                       // Chdir and Getwd
+	NoFileChdir                   // Chdir on an open file
+	NoLocks                       // Flock and FcntlFlock
+	ReadOnly                      // Anything that changes the filesystem
+)
+
+// Entry describes a file in Fixture.
+type Entry struct {
+	Name string      // Path relative to the test directory
+	Mode os.FileMode // Type and permissions
+	Data string      // Contents of a file, or the target of a symlink
+}
+
+// Fixture is the tree the read-only tests look at.  The suite creates it
+// itself on writable filesystems.  The symlink is left out when the
+// filesystem has NoSymlinks.
+var Fixture = []Entry{
+	{Name: "dir", Mode: os.ModeDir | 0755},
+	{Name: "dir/file", Mode: 0644, Data: "hello, world\n"},
+	{Name: "dir/empty", Mode: 0644},
+	{Name: "dir/sub", Mode: os.ModeDir | 0755},
+	{Name: "dir/sub/nested", Mode: 0600, Data: "nested file"},
+	{Name: "link", Mode: os.ModeSymlink | 0777, Data: "dir/file"},
+}
+
+// test is a single conformance check.
+type test struct {
+	name    string
+	skip    Flags // Lacking any of these skips the test
+	fixture bool  // Whether the test looks at Fixture
+	fn      func(t *testing.T, fs testfs.FileSystem, dir string)
+}
+
+// tests holds every check in the suite.  Each file adds its own.
+var tests []test
+
+func register(ts ...test) bool {
+	tests = append(tests, ts...)
+	return true
+}
+
+// Run runs the conformance suite as subtests of t, calling factory for a new
+// filesystem for each one.  lacks declares the features the filesystem does
+// not have.
+func Run(t *testing.T, factory Factory, lacks Flags) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if test.skip&lacks != 0 {
+				t.Skip("filesystem lacks a feature this test needs")
+			}
+
+			fs, dir := factory(t)
+			dir = strings.TrimSuffix(dir, "/")
+
+			if test.fixture && lacks&ReadOnly == 0 {
+				writeFixture(t, fs, dir, lacks&NoSymlinks == 0)
+			}
+
+			test.fn(t, fs, dir)
+		})
+	}
+}
+
+// Create the parts of Fixture which fs supports under dir.
+func writeFixture(t *testing.T, fs testfs.FileSystem, dir string, symlinks bool) {
+	for _, e := range Fixture {
+		name := dir + "/" + e.Name
+
+		switch {
+
+		case e.Mode.IsDir():
+			if err := fs.Mkdir(name, e.Mode.Perm()); err != nil {
+				t.Fatal(err)
+			}
+
+		case e.Mode&os.ModeSymlink != 0:
+			if !symlinks {
+				continue
+			}
+			if err := fs.Symlink(e.Data, name); err != nil {
+				t.Fatal(err)
+			}
+
+		default:
+			writeFile(t, fs, name, e.Data, e.Mode.Perm())
+
+		}
+	}
+}
+
+// Create name holding data.
+func writeFile(t *testing.T, fs testfs.FileSystem, name, data string, perm os.FileMode) {
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Return the contents of name.
+func readFile(t *testing.T, fs testfs.FileSystem, name string) string {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Check err is an *os.PathError for op carrying errno.
+func checkPathError(t *testing.T, err error, op string, errno syscall.Errno) {
+	t.Helper()
+
+	pe, ok := err.(*os.PathError)
+	if !ok {
+		t.Errorf("%s: got error %v (%T), want *os.PathError", op, err, err)
+		return
+	}
+	if pe.Op != op || !errors.Is(pe.Err, errno) {
+		t.Errorf("got %s: %v, want %s: %v", pe.Op, pe.Err, op, errno)
+	}
+}
+
+// Check err is an *os.LinkError for op carrying errno.
+func checkLinkError(t *testing.T, err error, op string, errno syscall.Errno) {
+	t.Helper()
+
+	le, ok := err.(*os.LinkError)
+	if !ok {
+		t.Errorf("%s: got error %v (%T), want *os.LinkError", op, err, err)
+		return
+	}
+	if le.Op != op || !errors.Is(le.Err, errno) {
+		t.Errorf("got %s: %v, want %s: %v", le.Op, le.Err, op, errno)
+	}
+}
+
+// Return the owner of a file, if fi comes from a filesystem we know.
+func owner(fi os.FileInfo) (uid, gid int, ok bool) {
+	if st, ok := fi.Sys().(*testfs.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+
+	return sysOwner(fi)
+}
+
+// Return the link count of a file, if fi comes from a filesystem we know.
+func nlink(fi os.FileInfo) (n uint64, ok bool) {
+	if st, ok := fi.Sys().(*testfs.Stat_t); ok {
+		return st.Nlink, true
+	}
+
+	return sysNlink(fi)
+}
@@ -0,0 +1,22 @@
+package fstests
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jfindley/testfs"
+)
+
+func TestTestFS(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+
+	Run(t, func(t *testing.T) (testfs.FileSystem, string) {
+		return testfs.NewTestFS(uid, gid).As(uid, gid), "/"
+	}, NoFileChdir)
+}
+
+func TestOSFS(t *testing.T) {
+	Run(t, func(t *testing.T) (testfs.FileSystem, string) {
+		return testfs.NewOSFS(), t.TempDir()
+	}, 0)
+}
@@ -0,0 +1,242 @@
+package fstests
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jfindley/testfs"
+)
+
+var _ = register(
+	test{name: "Symlink", skip: ReadOnly | NoSymlinks, fn: testSymlink},
+	test{name: "SymlinkRelative", skip: ReadOnly | NoSymlinks, fixture: true, fn: testSymlinkRelative},
+	test{name: "SymlinkLoop", skip: ReadOnly | NoSymlinks, fn: testSymlinkLoop},
+	test{name: "Readlink", skip: NoSymlinks, fixture: true, fn: testReadlink},
+	test{name: "Link", skip: ReadOnly | NoHardlinks, fn: testLink},
+	test{name: "Rename", skip: ReadOnly, fn: testRename},
+	test{name: "RenameErrors", skip: ReadOnly, fn: testRenameErrors},
+)
+
+func testSymlink(t *testing.T, fs testfs.FileSystem, dir string) {
+	writeFile(t, fs, dir+"/file", "data", 0644)
+
+	err := fs.Symlink(dir+"/file", dir+"/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Lstat(dir + "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != os.ModeSymlink {
+		t.Errorf("Lstat got mode %v", fi.Mode())
+	}
+	if fi.Name() != "link" {
+		t.Errorf("Lstat got name %q", fi.Name())
+	}
+
+	fi, err = fs.Stat(dir + "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != 4 {
+		t.Errorf("Stat got mode %v and size %d", fi.Mode(), fi.Size())
+	}
+
+	if got := readFile(t, fs, dir+"/link"); got != "data" {
+		t.Errorf("got %q through the link", got)
+	}
+
+	err = fs.Symlink(dir+"/file", dir+"/link")
+	checkLinkError(t, err, "symlink", syscall.EEXIST)
+
+	// Dangling links are fine until something follows them
+	err = fs.Symlink(dir+"/missing", dir+"/dangling")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Lstat(dir + "/dangling")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = fs.Stat(dir + "/dangling")
+	checkPathError(t, err, "stat", syscall.ENOENT)
+}
+
+func testSymlinkRelative(t *testing.T, fs testfs.FileSystem, dir string) {
+	// Relative targets are resolved from the directory holding the link
+	err := fs.Symlink("../file", dir+"/dir/sub/up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, fs, dir+"/dir/sub/up"); got != "hello, world\n" {
+		t.Errorf("got %q through a relative link", got)
+	}
+
+	// Links to directories can be walked through
+	err = fs.Symlink("dir/sub", dir+"/subdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, fs, dir+"/subdir/nested"); got != "nested file" {
+		t.Errorf("got %q through a directory link", got)
+	}
+}
+
+func testSymlinkLoop(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.Symlink("b", dir+"/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("a", dir+"/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Stat(dir + "/a")
+	checkPathError(t, err, "stat", syscall.ELOOP)
+
+	_, err = fs.Open(dir + "/a")
+	checkPathError(t, err, "open", syscall.ELOOP)
+}
+
+func testReadlink(t *testing.T, fs testfs.FileSystem, dir string) {
+	target, err := fs.Readlink(dir + "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "dir/file" {
+		t.Errorf("got target %q, want %q", target, "dir/file")
+	}
+
+	_, err = fs.Readlink(dir + "/dir/file")
+	checkPathError(t, err, "readlink", syscall.EINVAL)
+
+	_, err = fs.Readlink(dir + "/missing")
+	checkPathError(t, err, "readlink", syscall.ENOENT)
+}
+
+func testLink(t *testing.T, fs testfs.FileSystem, dir string) {
+	writeFile(t, fs, dir+"/file", "data", 0644)
+
+	err := fs.Link(dir+"/file", dir+"/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both names share the same data
+	f, err := fs.OpenFile(dir+"/link", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("changed")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, fs, dir+"/file"); got != "changed" {
+		t.Errorf("got %q through the original name", got)
+	}
+
+	// Removing one name leaves the other
+	err = fs.Remove(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, dir+"/link"); got != "changed" {
+		t.Errorf("got %q after removing the original name", got)
+	}
+
+	writeFile(t, fs, dir+"/other", "", 0644)
+
+	err = fs.Link(dir+"/link", dir+"/other")
+	checkLinkError(t, err, "link", syscall.EEXIST)
+
+	err = fs.Link(dir+"/missing", dir+"/new")
+	checkLinkError(t, err, "link", syscall.ENOENT)
+
+	err = fs.Mkdir(dir+"/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Link(dir+"/sub", dir+"/new")
+	checkLinkError(t, err, "link", syscall.EPERM)
+}
+
+func testRename(t *testing.T, fs testfs.FileSystem, dir string) {
+	writeFile(t, fs, dir+"/a", "a", 0644)
+	writeFile(t, fs, dir+"/b", "b", 0644)
+
+	err := fs.Rename(dir+"/a", dir+"/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.Lstat(dir + "/a")
+	checkPathError(t, err, "lstat", syscall.ENOENT)
+	if got := readFile(t, fs, dir+"/c"); got != "a" {
+		t.Errorf("got %q after rename", got)
+	}
+
+	// Renaming over a file replaces it
+	err = fs.Rename(dir+"/c", dir+"/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, dir+"/b"); got != "a" {
+		t.Errorf("got %q after replacing a file", got)
+	}
+
+	// Directories move with their contents
+	err = fs.MkdirAll(dir+"/x/y", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Rename(dir+"/b", dir+"/x/y/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Rename(dir+"/x", dir+"/z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, dir+"/z/y/b"); got != "a" {
+		t.Errorf("got %q after moving a directory", got)
+	}
+
+	// Renaming a file to itself does nothing
+	err = fs.Rename(dir+"/z/y/b", dir+"/z/y/b")
+	if err != nil {
+		t.Error(err)
+	}
+	if got := readFile(t, fs, dir+"/z/y/b"); got != "a" {
+		t.Errorf("got %q after renaming to itself", got)
+	}
+}
+
+func testRenameErrors(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.MkdirAll(dir+"/a/b", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, dir+"/file", "", 0644)
+
+	err = fs.Rename(dir+"/missing", dir+"/new")
+	checkLinkError(t, err, "rename", syscall.ENOENT)
+
+	err = fs.Rename(dir+"/a", dir+"/a/b/c")
+	checkLinkError(t, err, "rename", syscall.EINVAL)
+
+	err = fs.Rename(dir+"/a", dir+"/file")
+	checkLinkError(t, err, "rename", syscall.ENOTDIR)
+
+	err = fs.Rename(dir+"/file", dir+"/a")
+	checkLinkError(t, err, "rename", syscall.EEXIST)
+}
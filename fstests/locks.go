@@ -0,0 +1,169 @@
+package fstests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jfindley/testfs"
+)
+
+var _ = register(
+	test{name: "Flock", skip: ReadOnly | NoLocks, fn: testFlock},
+	test{name: "FcntlFlock", skip: ReadOnly | NoLocks, fn: testFcntlFlock},
+	test{name: "FcntlFlockOFD", skip: ReadOnly | NoLocks, fn: testFcntlFlockOFD},
+)
+
+// Open name for reading and writing twice, as two separate open files.
+func openLocked(t *testing.T, fs testfs.FileSystem, name string) (testfs.File, testfs.File) {
+	writeFile(t, fs, name, "", 0644)
+
+	var files [2]testfs.File
+	for n := range files {
+		f, err := fs.OpenFile(name, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		files[n] = f
+	}
+	return files[0], files[1]
+}
+
+// Skip the test if err shows the filesystem underneath has no locks.
+func skipLocks(t *testing.T, err error) {
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("file locks are not supported here")
+	}
+}
+
+// Set a lock of type typ on [start, start+n) through f.
+func setlk(f testfs.File, cmd int, typ int16, start, n int64) error {
+	lk := testfs.Flock_t{Type: typ, Start: start, Len: n}
+	return f.FcntlFlock(context.Background(), cmd, &lk)
+}
+
+// Return the lock which conflicts with a write lock on [start, start+n).
+func getlk(t *testing.T, f testfs.File, start, n int64) testfs.Flock_t {
+	t.Helper()
+
+	lk := testfs.Flock_t{Type: testfs.F_WRLCK, Start: start, Len: n}
+	if err := f.FcntlFlock(context.Background(), testfs.F_OFD_GETLK, &lk); err != nil {
+		t.Fatal(err)
+	}
+	return lk
+}
+
+func testFlock(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, g := openLocked(t, fs, dir+"/file")
+	ctx := context.Background()
+
+	err := f.Flock(ctx, testfs.LOCK_EX)
+	skipLocks(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = g.Flock(ctx, testfs.LOCK_SH|testfs.LOCK_NB)
+	checkPathError(t, err, "flock", syscall.EWOULDBLOCK)
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = g.Flock(timeout, testfs.LOCK_SH)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flock returned %v, want the context error", err)
+	}
+
+	// Closing the file drops its lock
+	f.Close()
+	if err := g.Flock(ctx, testfs.LOCK_EX|testfs.LOCK_NB); err != nil {
+		t.Error(err)
+	}
+
+	err = f.Flock(ctx, testfs.LOCK_UN)
+	checkPathError(t, err, "flock", syscall.EBADF)
+}
+
+func testFcntlFlock(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, g := openLocked(t, fs, dir+"/file")
+
+	h, err := fs.OpenFile(dir+"/file", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	err = setlk(f, testfs.F_SETLK, testfs.F_WRLCK, 0, 10)
+	skipLocks(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file opened by a process shares its locks
+	if err := setlk(g, testfs.F_SETLK, testfs.F_WRLCK, 5, 10); err != nil {
+		t.Error(err)
+	}
+
+	lk := getlk(t, h, 0, 0)
+	if lk.Type != testfs.F_WRLCK || lk.Start != 0 || lk.Len != 15 || lk.Pid <= 0 {
+		t.Errorf("F_OFD_GETLK returned %+v", lk)
+	}
+
+	err = setlk(h, testfs.F_SETLK, testfs.F_WRLCK, 20, 1)
+	checkPathError(t, err, "fcntl", syscall.EBADF)
+
+	// Closing any of them drops the locks
+	g.Close()
+	if lk := getlk(t, h, 0, 0); lk.Type != testfs.F_UNLCK {
+		t.Errorf("F_OFD_GETLK returned %+v after close", lk)
+	}
+}
+
+func testFcntlFlockOFD(t *testing.T, fs testfs.FileSystem, dir string) {
+	f, g := openLocked(t, fs, dir+"/file")
+
+	err := setlk(f, testfs.F_OFD_SETLK, testfs.F_WRLCK, 0, 10)
+	skipLocks(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = setlk(g, testfs.F_OFD_SETLK, testfs.F_RDLCK, 5, 1)
+	checkPathError(t, err, "fcntl", syscall.EAGAIN)
+
+	if err := setlk(g, testfs.F_OFD_SETLK, testfs.F_RDLCK, 10, 1); err != nil {
+		t.Error(err)
+	}
+
+	lk := getlk(t, g, 0, 0)
+	if lk.Type != testfs.F_WRLCK || lk.Start != 0 || lk.Len != 10 || lk.Pid != -1 {
+		t.Errorf("F_OFD_GETLK returned %+v", lk)
+	}
+
+	// A waiting lock is granted once the holder closes its file
+	got := make(chan error, 1)
+	go func() {
+		lk := testfs.Flock_t{Type: testfs.F_WRLCK, Len: 10}
+		got <- g.FcntlFlock(context.Background(), testfs.F_OFD_SETLKW, &lk)
+	}()
+
+	select {
+	case err := <-got:
+		t.Fatal("lock granted while held:", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f.Close()
+
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not granted after close")
+	}
+}
@@ -0,0 +1,270 @@
+package fstests
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jfindley/testfs"
+)
+
+var _ = register(
+	test{name: "StatErrors", fixture: true, fn: testStatErrors},
+	test{name: "Chmod", skip: ReadOnly | NoChmod, fn: testChmod},
+	test{name: "Chown", skip: ReadOnly | NoChown, fn: testChown},
+	test{name: "Lchown", skip: ReadOnly | NoChown | NoSymlinks, fn: testLchown},
+	test{name: "Chtimes", skip: ReadOnly | NoChtimes, fn: testChtimes},
+	test{name: "SetgidDir", skip: ReadOnly | NoChmod, fn: testSetgidDir},
+	test{name: "ChownSetuid", skip: ReadOnly | NoChmod | NoChown, fn: testChownSetuid},
+)
+
+func testStatErrors(t *testing.T, fs testfs.FileSystem, dir string) {
+	_, err := fs.Stat(dir + "/missing")
+	checkPathError(t, err, "stat", syscall.ENOENT)
+
+	_, err = fs.Lstat(dir + "/missing")
+	checkPathError(t, err, "lstat", syscall.ENOENT)
+
+	_, err = fs.Stat(dir + "/dir/file/sub")
+	checkPathError(t, err, "stat", syscall.ENOTDIR)
+
+	_, err = fs.Stat(dir + "/" + strings.Repeat("x", 256))
+	checkPathError(t, err, "stat", syscall.ENAMETOOLONG)
+}
+
+// Check name has permissions perm.
+func checkPerm(t *testing.T, fs testfs.FileSystem, name string, perm os.FileMode) {
+	t.Helper()
+
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != perm {
+		t.Errorf("got mode %v, want %v", fi.Mode().Perm(), perm)
+	}
+}
+
+func testChmod(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0644)
+
+	err := fs.Chmod(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPerm(t, fs, name, 0600)
+
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.Chmod(0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPerm(t, fs, name, 0640)
+
+	err = fs.Chmod(dir+"/missing", 0600)
+	checkPathError(t, err, "chmod", syscall.ENOENT)
+}
+
+func testChown(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0644)
+
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, gid, ok := owner(fi)
+	if !ok {
+		t.Skipf("cannot find the owner from %T", fi.Sys())
+	}
+
+	// Anyone may give a file they own to themselves
+	err = fs.Chown(name, uid, gid)
+	if err != nil {
+		t.Error(err)
+	}
+
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.Chown(uid, gid)
+	if err != nil {
+		t.Error(err)
+	}
+
+	fi, err = fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, g, _ := owner(fi); u != uid || g != gid {
+		t.Errorf("got owner %d:%d, want %d:%d", u, g, uid, gid)
+	}
+
+	// -1 leaves the owner and group alone
+	err = fs.Chown(name, -1, -1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Only root may give a file away
+	if uid != 0 {
+		err = fs.Chown(name, 0, -1)
+		checkPathError(t, err, "chown", syscall.EPERM)
+	}
+
+	err = fs.Chown(dir+"/missing", uid, gid)
+	checkPathError(t, err, "chown", syscall.ENOENT)
+}
+
+func testSetgidDir(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.Mkdir(dir+"/shared", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Chmod(dir+"/shared", os.ModeSetgid|0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(dir + "/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSetgid == 0 {
+		t.Fatalf("got mode %v, want setgid", fi.Mode())
+	}
+	_, gid, ok := owner(fi)
+	if !ok {
+		t.Skipf("cannot find the owner from %T", fi.Sys())
+	}
+
+	// New directories inherit the group and the setgid bit, new files
+	// only the group
+	err = fs.Mkdir(dir+"/shared/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, dir+"/shared/file", "", 0644)
+
+	fi, err = fs.Stat(dir + "/shared/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, g, _ := owner(fi); fi.Mode()&os.ModeSetgid == 0 || g != gid {
+		t.Errorf("got mode %v and group %d, want setgid and %d", fi.Mode(), g, gid)
+	}
+
+	fi, err = fs.Stat(dir + "/shared/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, g, _ := owner(fi); fi.Mode()&os.ModeSetgid != 0 || g != gid {
+		t.Errorf("got mode %v and group %d, want group %d", fi.Mode(), g, gid)
+	}
+}
+
+func testChownSetuid(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0755)
+
+	err := fs.Chmod(name, os.ModeSetuid|os.ModeSetgid|0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, gid, ok := owner(fi)
+	if !ok {
+		t.Skipf("cannot find the owner from %T", fi.Sys())
+	}
+
+	// Changing the owner, even to the same one, drops setuid and setgid
+	err = fs.Chown(name, uid, gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.FileMode(0755))
+	}
+}
+
+func testLchown(t *testing.T, fs testfs.FileSystem, dir string) {
+	err := fs.Symlink("missing", dir+"/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Lstat(dir + "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, gid, ok := owner(fi)
+	if !ok {
+		t.Skipf("cannot find the owner from %T", fi.Sys())
+	}
+
+	// Lchown works on the link itself, so a dangling link is fine
+	err = fs.Lchown(dir+"/link", uid, gid)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Chown(dir+"/link", uid, gid)
+	checkPathError(t, err, "chown", syscall.ENOENT)
+}
+
+func testChtimes(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0644)
+
+	mtime := time.Unix(1000000000, 0)
+	err := fs.Chtimes(name, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("got mtime %v, want %v", fi.ModTime(), mtime)
+	}
+
+	// A zero time leaves that timestamp alone
+	err = fs.Chtimes(name, time.Unix(1100000000, 0), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("got mtime %v after a zero mtime, want %v", fi.ModTime(), mtime)
+	}
+
+	err = fs.Chtimes(dir+"/missing", mtime, mtime)
+	checkPathError(t, err, "chtimes", syscall.ENOENT)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package fstests
+
+import "os"
+
+// The os package reports no owner outside Unix.
+func sysOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// The os package reports no link count outside Unix.
+func sysNlink(fi os.FileInfo) (n uint64, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package fstests
+
+import (
+	"os"
+	"syscall"
+)
+
+// Return the owner of a file from the os package.
+func sysOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+
+	return 0, 0, false
+}
+
+// Return the link count of a file from the os package.
+func sysNlink(fi os.FileInfo) (n uint64, ok bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink), true
+	}
+
+	return 0, false
+}
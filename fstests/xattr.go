@@ -0,0 +1,150 @@
+package fstests
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jfindley/testfs"
+)
+
+var _ = register(
+	test{name: "Xattr", skip: ReadOnly | NoXattrs, fn: testXattr},
+	test{name: "Lxattr", skip: ReadOnly | NoXattrs, fn: testLxattr},
+	test{name: "FileXattr", skip: ReadOnly | NoXattrs, fn: testFileXattr},
+)
+
+// Set a first attribute on name, skipping the test if the filesystem
+// underneath does not support them.
+func setxattr(t *testing.T, fs testfs.FileSystem, name, attr, data string) {
+	err := fs.Setxattr(name, attr, []byte(data), 0)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("extended attributes are not supported here")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testXattr(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0644)
+
+	setxattr(t, fs, name, "user.a", "one")
+
+	err := fs.Setxattr(name, "user.b", []byte("two"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.Getxattr(name, "user.a")
+	if err != nil || string(data) != "one" {
+		t.Errorf("Getxattr returned %q, %v", data, err)
+	}
+
+	names, err := fs.Listxattr(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "user.a,user.b" {
+		t.Errorf("Listxattr returned %v", names)
+	}
+
+	err = fs.Setxattr(name, "user.a", []byte("three"), testfs.XATTR_CREATE)
+	checkPathError(t, err, "setxattr", syscall.EEXIST)
+
+	err = fs.Setxattr(name, "user.c", []byte("three"), testfs.XATTR_REPLACE)
+	checkPathError(t, err, "setxattr", testfs.ENOATTR)
+
+	err = fs.Removexattr(name, "user.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Getxattr(name, "user.a")
+	checkPathError(t, err, "getxattr", testfs.ENOATTR)
+
+	err = fs.Removexattr(name, "user.a")
+	checkPathError(t, err, "removexattr", testfs.ENOATTR)
+
+	_, err = fs.Getxattr(dir+"/missing", "user.a")
+	checkPathError(t, err, "getxattr", syscall.ENOENT)
+}
+
+func testLxattr(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0644)
+
+	setxattr(t, fs, name, "user.a", "one")
+
+	err := fs.Lsetxattr(name, "user.b", []byte("two"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.Lgetxattr(name, "user.b")
+	if err != nil || string(data) != "two" {
+		t.Errorf("Lgetxattr returned %q, %v", data, err)
+	}
+
+	names, err := fs.Llistxattr(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "user.a,user.b" {
+		t.Errorf("Llistxattr returned %v", names)
+	}
+
+	err = fs.Lremovexattr(name, "user.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Lgetxattr(name, "user.b")
+	checkPathError(t, err, "lgetxattr", testfs.ENOATTR)
+}
+
+func testFileXattr(t *testing.T, fs testfs.FileSystem, dir string) {
+	name := dir + "/file"
+	writeFile(t, fs, name, "", 0644)
+
+	setxattr(t, fs, name, "user.a", "one")
+
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := f.Getxattr("user.a")
+	if err != nil || string(data) != "one" {
+		t.Errorf("Getxattr returned %q, %v", data, err)
+	}
+
+	err = f.Setxattr("user.b", []byte("two"), testfs.XATTR_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := f.Listxattr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "user.a,user.b" {
+		t.Errorf("Listxattr returned %v", names)
+	}
+
+	err = f.Removexattr("user.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Getxattr("user.b")
+	checkPathError(t, err, "fgetxattr", testfs.ENOATTR)
+}
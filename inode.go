@@ -1,55 +1,157 @@
 package testfs
 
 import (
+	"math"
 	"os"
-	"path"
+	"sort"
+	"syscall"
 	"time"
 )
 
-// Methods to implement os.FileInfo
+// Methods to implement os.FileInfo.  These read the live inode, so each
+// value may change between calls.  Stat and friends return a fileInfo
+// instead, which is a consistent snapshot.
 func (i *inode) Name() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	return i.name
 }
 
 func (i *inode) Size() int64 {
-	return int64(len(i.data))
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+	return i.size()
+}
+
+// Unsafe.  The caller must hold i.attr.
+func (i *inode) size() int64 {
+	// The size of a symlink is the length of its target
+	if i.mode&os.ModeSymlink != 0 {
+		return int64(len(i.relName))
+	}
+	return i.data.length
 }
 
 func (i *inode) Mode() os.FileMode {
+	i.attr.RLock()
+	defer i.attr.RUnlock()
 	return i.mode
 }
 
 func (i *inode) ModTime() time.Time {
+	i.attr.RLock()
+	defer i.attr.RUnlock()
 	return i.mtime
 }
 
 func (i *inode) IsDir() bool {
-	if i.mode&os.ModeDir == 0 {
-		return false
-	}
-	return true
+	return i.Mode().IsDir()
 }
 
 func (i *inode) Sys() interface{} {
-	return &Stat_t{
-		Name:     i.name,
+	return i.stat(i.Name()).Sys()
+}
+
+// fileInfo is a snapshot of an inode as seen through one of its names.  A
+// hard linked inode has several, and its FileInfo should report the one it
+// was reached by.
+type fileInfo struct {
+	sys Stat_t
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.sys.Name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.sys.Size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	return fi.sys.Mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.sys.Mtime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.sys.Mode.IsDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return &fi.sys
+}
+
+// Return a snapshot of the attributes of i, named name.
+func (i *inode) stat(name string) *fileInfo {
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+
+	xattrs := make(map[string]string, len(i.xattrs))
+	for k, v := range i.xattrs {
+		xattrs[k] = v
+	}
+
+	return &fileInfo{Stat_t{
+		Name:     name,
 		Uid:      i.uid,
 		Gid:      i.gid,
 		Mode:     i.mode,
-		Xattrs:   i.xattrs,
+		Xattrs:   xattrs,
+		Atime:    i.atime,
 		Mtime:    i.mtime,
-		Size:     int64(len(i.data)),
+		Ctime:    i.ctime,
+		Btime:    i.btime,
+		Atim:     syscall.NsecToTimespec(i.atime.UnixNano()),
+		Mtim:     syscall.NsecToTimespec(i.mtime.UnixNano()),
+		Ctim:     syscall.NsecToTimespec(i.ctime.UnixNano()),
+		Btim:     syscall.NsecToTimespec(i.btime.UnixNano()),
+		Size:     i.size(),
+		Nlink:    uint64(i.linkCount),
+		Blocks:   i.data.allocated(),
+		Blksize:  blockSize,
 		Linkname: i.relName,
+	}}
+}
+
+// Return a FileInfo for i named after the last element of path, as os does.
+func named(i *inode, path string) os.FileInfo {
+	if _, file := splitPath(path); file != "" {
+		return i.stat(file)
 	}
+	return i.stat(i.Name())
+}
+
+// Return the target of i, and whether it is a symlink at all.
+func (i *inode) target() (string, bool) {
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+	return i.relName, i.mode&os.ModeSymlink != 0
+}
+
+// Record a change to the contents of i, such as a directory entry being
+// added or removed.
+func (i *inode) touch(now time.Time) {
+	i.attr.Lock()
+	defer i.attr.Unlock()
+	i.mtime = now
+	i.ctime = now
 }
 
-func (i *inode) chmod(mode os.FileMode) error {
-	if !checkPerm(i, 'r') {
-		return os.ErrPermission
+// Only the owner of a file or root may change its mode, as with chmod(2).
+// The setgid bit is dropped if the caller is not in the group of the file.
+func (i *inode) chmod(c *cred, mode os.FileMode) error {
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	if c.uid != 0 && c.uid != i.uid {
+		return syscall.EPERM
 	}
 
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	if c.uid != 0 && !c.inGroup(i.gid) {
+		mode &^= os.ModeSetgid
+	}
 
 	// Blank out existing permission bits
 	perm := i.mode
@@ -64,52 +166,165 @@ func (i *inode) chmod(mode os.FileMode) error {
 	perm |= mode
 
 	i.mode = perm
+	i.ctime = i.sb.now()
 	return nil
 }
 
-func (i *inode) chown(uid, gid int) error {
-	if !checkPerm(i, 'r') {
-		return os.ErrPermission
+// Change the ownership of i, as chown(2) does.  An id of -1 is left alone.
+// Root may give a file to anyone, but its owner may only change its group,
+// and only to one of their own groups.  As on Linux, this clears the setuid
+// and setgid bits of anything but a directory, even when done by root.
+func (i *inode) chown(c *cred, uid, gid int) error {
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	newUid, err := chownID(uid, i.uid)
+	if err != nil {
+		return err
+	}
+	newGid, err := chownID(gid, i.gid)
+	if err != nil {
+		return err
+	}
+
+	// As on Linux, only the ids being changed are checked, but anyone
+	// else clearing the setuid bit must still own the file.
+	if c.uid != 0 {
+		owner := c.uid == i.uid
+		switch {
+		case !keepID(uid) && (!owner || newUid != i.uid):
+			return syscall.EPERM
+		case !keepID(gid) && (!owner || newGid != i.gid && !c.inGroup(newGid)):
+			return syscall.EPERM
+		case !owner && !i.mode.IsDir() && i.hasSuid():
+			return syscall.EPERM
+		}
 	}
 
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	i.uid = newUid
+	i.gid = newGid
+	if !i.mode.IsDir() {
+		i.killSuid()
+	}
+	i.ctime = i.sb.now()
+	return nil
+}
+
+// Report whether id asks chown to leave an id alone.  Ids are 32 bits, and
+// the largest also means -1, as that is how the kernel sees it.
+func keepID(id int) bool {
+	return id == -1 || int64(id) == math.MaxUint32
+}
+
+// Convert an id given to chown, returning cur for -1.
+func chownID(id int, cur uint32) (uint32, error) {
+	switch {
+
+	case keepID(id):
+		return cur, nil
+
+	case id < 0 || int64(id) > math.MaxUint32:
+		return 0, syscall.EINVAL
+
+	}
+
+	return uint32(id), nil
+}
+
+// Unsafe.  Clear the setuid bit of i, and the setgid bit if it is group
+// executable.  A setgid file without group execute marks it for mandatory
+// locking rather than granting anything, so Linux leaves it alone.  The
+// caller must hold i.attr.
+func (i *inode) killSuid() {
+	i.mode &^= os.ModeSetuid
+	if i.mode&0010 != 0 {
+		i.mode &^= os.ModeSetgid
+	}
+}
+
+// Unsafe.  Report whether killSuid would change i.  The caller must hold
+// i.attr.
+func (i *inode) hasSuid() bool {
+	return i.mode&os.ModeSetuid != 0 || i.mode&os.ModeSetgid != 0 && i.mode&0010 != 0
+}
+
+// Unsafe.  Drop the privileges of a regular file changed by c, as a write
+// or truncate does on Linux unless made by root.  The caller must hold
+// i.attr.
+func (i *inode) modified(c *cred) {
+	if c.uid != 0 && i.mode.IsRegular() {
+		i.killSuid()
+	}
+}
+
+// Setting explicit timestamps is restricted to the owner of the file, as
+// with utimes(2).  A zero time leaves that timestamp alone, as os.Chtimes
+// does.
+func (i *inode) chtimes(c *cred, atime, mtime time.Time) error {
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	if (!atime.IsZero() || !mtime.IsZero()) && c.uid != 0 && c.uid != i.uid {
+		return syscall.EPERM
+	}
 
-	i.uid = uint16(uid)
-	i.gid = uint16(gid)
+	if !atime.IsZero() {
+		i.atime = atime
+	}
+	if !mtime.IsZero() {
+		i.mtime = mtime
+	}
+	i.ctime = i.sb.now()
 	return nil
 }
 
 func (t *TestFS) Chmod(name string, mode os.FileMode) error {
 	f, err := t.find(name)
 	if err != nil {
-		return err
+		return pathError("chmod", name, err)
 	}
 
-	return f.chmod(mode)
+	return pathError("chmod", name, f.chmod(&t.cred, mode))
 }
 
 func (t *TestFS) Chown(name string, uid, gid int) error {
 	f, err := t.find(name)
 	if err != nil {
-		return err
+		return pathError("chown", name, err)
 	}
 
-	return f.chown(uid, gid)
+	return pathError("chown", name, f.chown(&t.cred, uid, gid))
 }
 
-func (t *TestFS) Link(oldname, newname string) error {
+func (t *TestFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	f, err := t.find(name)
+	if err != nil {
+		return pathError("chtimes", name, err)
+	}
 
-	newDir, newFile := path.Split(newname)
+	return pathError("chtimes", name, f.chtimes(&t.cred, atime, mtime))
+}
 
-	tar, err := t.find(oldname)
+func (t *TestFS) Lchown(name string, uid, gid int) error {
+	f, err := t.lfind(name)
 	if err != nil {
-		return err
+		return pathError("lchown", name, err)
 	}
 
-	// No hardlinks to directories
-	if tar.IsDir() {
-		return os.ErrInvalid
+	return pathError("lchown", name, f.chown(&t.cred, uid, gid))
+}
+
+func (t *TestFS) Link(oldname, newname string) error {
+	return linkError("link", oldname, newname, t.link(oldname, newname))
+}
+
+func (t *TestFS) link(oldname, newname string) error {
+
+	newDir, newFile := splitPath(newname)
+
+	tar, err := t.lfind(oldname)
+	if err != nil {
+		return err
 	}
 
 	dir, err := t.find(newDir)
@@ -117,208 +332,575 @@ func (t *TestFS) Link(oldname, newname string) error {
 		return err
 	}
 
+	if !dir.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	if err := checkName(newFile); err != nil {
+		return err
+	}
+
 	dir.mu.Lock()
 	defer dir.mu.Unlock()
 
-	if _, ok := dir.children[newFile]; ok {
-		return os.ErrExist
+	// Check in the same order as newSkipLock
+	if !checkPerm(&t.cred, dir, 'x') {
+		return syscall.EACCES
+	}
+
+	if _, ok := dir.children[newFile]; ok || isDot(newFile) {
+		return syscall.EEXIST
+	}
+
+	// A trailing slash asks for a directory, which a link cannot be
+	if trailingSlash(newname) {
+		return syscall.ENOENT
+	}
+
+	if dir.dead() {
+		return syscall.ENOENT
+	}
+
+	if !checkPerm(&t.cred, dir, 'w') {
+		return syscall.EACCES
+	}
+
+	// No hardlinks to directories
+	if tar.IsDir() {
+		return syscall.EPERM
 	}
 
+	now := dir.sb.now()
 	dir.children[newFile] = tar
-	dir.mtime = time.Now()
+	dir.touch(now)
+
+	tar.attr.Lock()
+	defer tar.attr.Unlock()
 	tar.linkCount++
+	tar.ctime = now
 
 	return nil
 }
 
 func (t *TestFS) Readlink(name string) (string, error) {
-	dir, file := path.Split(name)
-
-	d, err := t.find(dir)
+	f, err := t.lfind(name)
 	if err != nil {
-		return "", err
+		return "", pathError("readlink", name, err)
 	}
 
-	f, err := d.lookupSymlink(file)
+	f.attr.Lock()
+	defer f.attr.Unlock()
+
+	if f.mode&os.ModeSymlink == 0 {
+		return "", pathError("readlink", name, syscall.EINVAL)
+	}
 
-	return f.relName, err
+	f.atime = f.sb.now()
 
+	return f.relName, nil
 }
 
 func (t *TestFS) Remove(name string) error {
-	return t.rmHelper(name, unlink)
+	return pathError("remove", name, t.remove(name))
+}
+
+// remove tries to remove name as a file and then as a directory, returning
+// the more useful error, as os.Remove does.
+func (t *TestFS) remove(name string) error {
+	dir, file := splitPath(name)
+
+	switch {
+
+	case name == "":
+		return syscall.ENOENT
+
+	case file == "":
+		// Removing the root directory
+		return syscall.EBUSY
+
+	case isDot(file):
+		return syscall.EINVAL
+
+	}
 
+	d, err := t.find(dir)
+	if err != nil {
+		return err
+	}
+
+	// Only a directory can be removed through a name with a trailing
+	// slash.  Unlinking it as a file would fail with EISDIR or ENOTDIR,
+	// which rmdir reports just as well.
+	if trailingSlash(name) {
+		return d.unlinkat(&t.cred, file, true)
+	}
+
+	err = d.unlinkat(&t.cred, file, false)
+	if err == nil {
+		return nil
+	}
+
+	err1 := d.unlinkat(&t.cred, file, true)
+	if err1 == nil {
+		return nil
+	}
+
+	if err1 != syscall.ENOTDIR {
+		err = err1
+	}
+	return err
 }
 
+// RemoveAll removes entries one at a time, checking permissions as it goes,
+// in the same way as os.RemoveAll.  It may leave part of the tree behind.
 func (t *TestFS) RemoveAll(name string) error {
-	return t.rmHelper(name, unlinkall)
+	if name == "" {
+		return nil
+	}
+
+	if _, file := splitPath(name); isDot(file) {
+		return pathError("RemoveAll", name, syscall.EINVAL)
+	}
+
+	// Simple case: if Remove works, we're done
+	err := t.remove(name)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	dir, file := splitPath(name)
+
+	parent, err := t.find(dir)
+	if os.IsNotExist(err) {
+		// If the parent does not exist, neither does name
+		return nil
+	}
+	if err == nil && !checkPerm(&t.cred, parent, 'r') {
+		err = syscall.EACCES
+	}
+	if err != nil {
+		return pathError("open", dir, err)
+	}
+
+	err = t.removeAllFrom(parent, file)
+	if pe, ok := err.(*os.PathError); ok {
+		pe.Path = dir + "/" + pe.Path
+	}
+	return err
+}
+
+func (t *TestFS) removeAllFrom(parent *inode, base string) error {
+	// Simple case: if unlinking works, we're done
+	err := parent.unlinkat(&t.cred, base, false)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	// EISDIR means we have a directory whose contents must go first.
+	// EACCES or EPERM may still mean a directory whose contents we can
+	// remove.  Anything else is a real failure.
+	if err != syscall.EISDIR && err != syscall.EPERM && err != syscall.EACCES {
+		return pathError("unlinkat", base, err)
+	}
+	uErr := err
+
+	dir, err := parent.lookup(&t.cred, []string{base}, false)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil && !dir.IsDir() {
+		return pathError("unlinkat", base, uErr)
+	}
+	if err == nil && !checkPerm(&t.cred, dir, 'r') {
+		err = syscall.EACCES
+	}
+
+	var recurseErr error
+
+	if err != nil {
+		recurseErr = pathError("openfdat", base, err)
+	} else {
+		dir.mu.Lock()
+		names := make([]string, 0, len(dir.children))
+		for name := range dir.children {
+			names = append(names, name)
+		}
+		dir.mu.Unlock()
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			err := t.removeAllFrom(dir, name)
+			if err == nil {
+				continue
+			}
+			if pe, ok := err.(*os.PathError); ok {
+				pe.Path = base + "/" + pe.Path
+			}
+			if recurseErr == nil {
+				recurseErr = err
+			}
+		}
+	}
+
+	// Remove the directory itself
+	err = parent.unlinkat(&t.cred, base, true)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	if recurseErr != nil {
+		return recurseErr
+	}
+	return pathError("unlinkat", base, err)
 }
 
 func (t *TestFS) Rename(oldpath, newpath string) error {
+	return linkError("rename", oldpath, newpath, t.rename(oldpath, newpath))
+}
+
+// rename moves oldpath to newpath, replacing anything already there as
+// rename(2) does.
+func (t *TestFS) rename(oldpath, newpath string) error {
+
+	// The os package refuses to replace a directory, even an empty one,
+	// so that Rename behaves the same on every platform.  It checks with
+	// Lstat before renaming, so a trailing slash follows a symlink, and a
+	// failure to find oldpath is reported first.
+	if dst, err := t.lfind(newpath); err == nil && dst.IsDir() {
+		src, err := t.lfind(oldpath)
+		if err != nil {
+			return err
+		}
+		if src != dst || oldpath == newpath {
+			return syscall.EEXIST
+		}
+	}
+
+	newDir, newFile := splitPath(newpath)
+	oldDir, oldFile := splitPath(oldpath)
+
+	for _, name := range []string{oldFile, newFile} {
+		if name == "" || isDot(name) {
+			return syscall.EINVAL
+		}
+	}
 
-	newDir, newFile := path.Split(newpath)
-	oldDir, oldFile := path.Split(oldpath)
+	if err := checkName(newFile); err != nil {
+		return err
+	}
 
+	// Both parents are resolved before either entry is looked up, as
+	// rename(2) does.
 	srcDir, err := t.find(oldDir)
 	if err != nil {
 		return err
 	}
 
-	if !checkPerm(srcDir, 'r', 'w', 'x') {
-		return os.ErrPermission
+	if !srcDir.IsDir() {
+		return syscall.ENOTDIR
 	}
 
-	srcDir.mu.Lock()
-	defer srcDir.mu.Unlock()
+	if !checkPerm(&t.cred, srcDir, 'x') {
+		return syscall.EACCES
+	}
 
-	src, err := srcDir.lookup([]string{oldFile})
+	dstDir, err := t.find(newDir)
 	if err != nil {
 		return err
 	}
 
-	dstDir, err := t.find(newDir)
+	if !dstDir.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	if !checkPerm(&t.cred, dstDir, 'x') {
+		return syscall.EACCES
+	}
+
+	lockRename(srcDir, dstDir)
+	defer unlockRename(srcDir, dstDir)
+
+	src, err := srcDir.childSkipLock(&t.cred, oldFile)
 	if err != nil {
 		return err
 	}
 
-	if !checkPerm(dstDir, 'r', 'w', 'x') {
-		return os.ErrPermission
+	// A trailing slash on either name means a directory
+	if !src.IsDir() && (trailingSlash(oldpath) || trailingSlash(newpath)) {
+		return syscall.ENOTDIR
+	}
+
+	if dstDir.dead() {
+		return syscall.ENOENT
+	}
+
+	dst, ok := dstDir.children[newFile]
+
+	if ok && dst.IsDir() && (dst != src || oldpath == newpath) {
+		// As above, in case the directory appeared since
+		return syscall.EEXIST
 	}
 
-	srcDir.mtime = time.Now()
+	// A directory cannot be moved inside itself.  Only a rename between
+	// directories could do that, and the rename lock it holds keeps the
+	// parents still while we look.
+	if src.IsDir() && srcDir != dstDir {
+		for d := dstDir; d != nil; d = d.parent {
+			if d == src {
+				return syscall.EINVAL
+			}
+		}
+	}
 
-	if srcDir != dstDir {
-		dstDir.mu.Lock()
-		defer dstDir.mu.Unlock()
+	// Renaming a file over itself (or another link to it) does nothing
+	if ok && dst == src {
+		return nil
+	}
 
-		dstDir.mtime = time.Now()
+	if !checkPerm(&t.cred, srcDir, 'w', 'x') || !checkPerm(&t.cred, dstDir, 'w', 'x') {
+		return syscall.EACCES
 	}
 
-	_, err = dstDir.lookup([]string{newFile})
-	if !os.IsNotExist(err) {
-		return os.ErrExist
+	if !checkSticky(&t.cred, srcDir, src) || ok && !checkSticky(&t.cred, dstDir, dst) {
+		return syscall.EPERM
 	}
 
+	if ok && src.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	// Moving a directory to a new parent rewrites its .. entry
+	if src.IsDir() && srcDir != dstDir && !checkPerm(&t.cred, src, 'w') {
+		return syscall.EACCES
+	}
+
+	if ok {
+		unlink(dst)
+	}
+
+	now := srcDir.sb.now()
+	srcDir.touch(now)
+	dstDir.touch(now)
+
 	dstDir.children[newFile] = src
 	delete(srcDir.children, oldFile)
 
+	// The parent only changes, and so is only written, in a rename
+	// between directories, which path excludes with the rename lock
+	src.mu.Lock()
+	src.name = newFile
+	if src.IsDir() && srcDir != dstDir {
+		src.parent = dstDir
+	}
+	src.mu.Unlock()
+
+	src.attr.Lock()
+	src.ctime = now
+	src.attr.Unlock()
+
 	return nil
 }
 
+// Lock the directories of a rename, as lock_rename does on Linux.  A rename
+// within one directory only needs that directory.  A rename between two
+// takes the filesystem rename lock first, so that no other rename can change
+// the shape of the tree, and then locks the directories ancestor first.
+// Everything else only ever locks a directory and then a child of it, so it
+// can never be waiting on a rename which is waiting on it.
+func lockRename(srcDir, dstDir *inode) {
+	if srcDir == dstDir {
+		srcDir.mu.Lock()
+		return
+	}
+
+	srcDir.sb.rename.Lock()
+
+	first, second := srcDir, dstDir
+	for d := srcDir.parent; d != nil; d = d.parent {
+		if d == dstDir {
+			first, second = dstDir, srcDir
+			break
+		}
+	}
+
+	first.mu.Lock()
+	second.mu.Lock()
+}
+
+// Undo lockRename.
+func unlockRename(srcDir, dstDir *inode) {
+	srcDir.mu.Unlock()
+	if srcDir == dstDir {
+		return
+	}
+
+	dstDir.mu.Unlock()
+	srcDir.sb.rename.Unlock()
+}
+
+// Symlink creates newname as a symlink to oldname.  The target is stored
+// as given and only resolved when the link is followed, so it may be
+// relative, or not exist yet.
 func (t *TestFS) Symlink(oldname, newname string) error {
+	return linkError("symlink", oldname, newname, t.symlink(oldname, newname))
+}
 
-	newDir, newFile := path.Split(newname)
+func (t *TestFS) symlink(oldname, newname string) error {
 
-	dst, err := t.find(oldname)
-	if err != nil {
-		return err
+	if oldname == "" || newname == "" {
+		return syscall.ENOENT
 	}
 
-	srcDir, err := t.find(path.Dir(newname))
+	newDir, newFile := splitPath(newname)
+
+	dir, err := t.find(newDir)
 	if err != nil {
 		return err
 	}
 
-	if !checkPerm(srcDir, 'r', 'w', 'x') {
-		return os.ErrPermission
+	if !dir.IsDir() {
+		return syscall.ENOTDIR
 	}
 
-	_, err = srcDir.lookup([]string{newDir})
-	if !os.IsNotExist(err) {
-		return os.ErrExist
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+
+	// A trailing slash asks for a directory, so a name which is not taken
+	// cannot be used
+	if trailingSlash(newname) {
+		if _, err := dir.childSkipLock(&t.cred, newFile); err != nil {
+			return err
+		}
+		return syscall.EEXIST
 	}
 
-	err = srcDir.new(newFile, Uid, Gid, os.FileMode(0777)|os.ModeSymlink)
+	// Creating the link checks for write and search access to dir, as for
+	// any other new entry.  Reading dir is not needed.
+	err = dir.newSkipLock(&t.cred, newFile, t.cred.uid, t.cred.gid, os.FileMode(0777)|os.ModeSymlink)
 	if err != nil {
 		return err
 	}
 
-	srcDir.children[newFile].rel = dst
-	srcDir.children[newFile].relName = oldname
-
-	srcDir.mtime = time.Now()
+	l := dir.children[newFile]
+	l.attr.Lock()
+	l.relName = oldname
+	l.attr.Unlock()
 
 	return nil
 }
 
 func (t *TestFS) Lstat(name string) (os.FileInfo, error) {
-	dir, file := path.Split(name)
-
-	d, err := t.find(dir)
+	f, err := t.lfind(name)
 	if err != nil {
-		return nil, err
+		return nil, pathError("lstat", name, err)
 	}
 
-	return d.lookupSymlink(file)
+	return named(f, name), nil
 }
 
-func (t *TestFS) Stat(path string) (os.FileInfo, error) {
-	return t.find(path)
+func (t *TestFS) Stat(name string) (os.FileInfo, error) {
+	f, err := t.find(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return named(f, name), nil
 }
 
+// Drop a link to in.  Once the last link is gone the data is freed, but not
+// until the last open file referring to it is closed.
 func unlink(in *inode) {
-	in.mu.Lock()
-	defer in.mu.Unlock()
+	in.attr.Lock()
+	defer in.attr.Unlock()
 
-	in.mtime = time.Now()
+	in.ctime = in.sb.now()
 
 	in.linkCount--
+	in.release()
+}
 
-	if in.linkCount == 0 {
-		in = nil
+// Record an open file referring to i.  Once the last link to a file is gone
+// it cannot be reached by name, so a lookup which raced with the unlink must
+// not open it.  A removed working directory can still be opened as ".", as
+// on Linux.
+func (i *inode) open() error {
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	if i.linkCount == 0 && !i.mode.IsDir() {
+		return syscall.ENOENT
 	}
-	return
+
+	i.openCount++
+	return nil
 }
 
-func unlinkall(in *inode) {
-	in.mu.Lock()
+// Drop an open file referring to i, freeing it if it was the last reference.
+func (i *inode) close() {
+	i.attr.Lock()
+	defer i.attr.Unlock()
 
-	in.mtime = time.Now()
+	i.openCount--
+	i.release()
+}
 
-	if !in.IsDir() {
-		in.mu.Unlock()
-		unlink(in)
-		return
+// Unsafe.  Free the data of i if nothing refers to it any more.  The caller
+// must hold i.attr.
+func (i *inode) release() {
+	if i.linkCount == 0 && i.openCount == 0 {
+		i.data = fileData{}
+		i.xattrs = make(map[string]string)
 	}
+}
 
-	for name, child := range in.children {
-		if name == ".." {
-			continue
-		}
-		unlinkall(child)
+// Remove the entry name from directory d, as unlinkat(2) does.  rmdir
+// selects between removing an empty directory and anything else.
+func (d *inode) unlinkat(c *cred, name string, rmdir bool) error {
+	if !d.IsDir() {
+		return syscall.ENOTDIR
 	}
 
-	in.mu.Unlock()
-	unlink(in)
-	return
-}
-
-func (t *TestFS) rmHelper(name string, unlinkfunc func(*inode)) error {
-	dir, file := path.Split(name)
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	d, err := t.find(dir)
+	f, err := d.childSkipLock(c, name)
 	if err != nil {
 		return err
 	}
 
-	if !checkPerm(d, 'r', 'w', 'x') {
-		return os.ErrPermission
+	if !checkPerm(c, d, 'w', 'x') {
+		return syscall.EACCES
 	}
 
-	d.mu.Lock()
-	defer d.mu.Unlock()
+	if !checkSticky(c, d, f) {
+		return syscall.EPERM
+	}
+
+	switch {
+
+	case rmdir && !f.IsDir():
+		return syscall.ENOTDIR
+
+	case !rmdir && f.IsDir():
+		return syscall.EISDIR
 
-	f, err := d.lookup([]string{file})
-	if err != nil {
-		return err
 	}
 
-	if !checkPerm(f, 'w') {
-		return os.ErrPermission
+	// Hold a directory while it is removed, so that nothing can be
+	// created in it once it has been found empty
+	if rmdir {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if len(f.children) > 0 {
+			return syscall.ENOTEMPTY
+		}
 	}
 
-	unlinkfunc(f)
-	delete(d.children, file)
-	d.mtime = time.Now()
+	unlink(f)
+
+	delete(d.children, name)
+	d.touch(d.sb.now())
 	return nil
 }
@@ -1,8 +1,15 @@
 package testfs
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestInodeFileInfo(t *testing.T) {
@@ -17,7 +24,7 @@ func TestInodeFileInfo(t *testing.T) {
 
 	fileInfo = in
 
-	in.data = []byte("testdata")
+	in.data = newFileData([]byte("testdata"))
 
 	if fileInfo.Name() != "testfileinfo" {
 		t.Error("Bad name")
@@ -27,7 +34,8 @@ func TestInodeFileInfo(t *testing.T) {
 		t.Error("Bad dir status")
 	}
 
-	if fileInfo.Mode() != os.FileMode(0775)|os.ModeDir {
+	// The default umask clears group write
+	if fileInfo.Mode() != os.FileMode(0755)|os.ModeDir {
 		t.Error("Bad file mode")
 	}
 
@@ -35,14 +43,14 @@ func TestInodeFileInfo(t *testing.T) {
 		t.Error("Bad modtime")
 	}
 
-	if fileInfo.Size() != int64(len(in.data)) {
+	if fileInfo.Size() != in.data.length {
 		t.Error("Bad size")
 	}
 
 }
 
 func TestChmod(t *testing.T) {
-	fs.dirTree.new("testchmod", Uid, Gid, os.FileMode(0755))
+	fs.dirTree.new(&fs.cred, "testchmod", fs.cred.uid, fs.cred.gid, os.FileMode(0755))
 	err := fs.Chmod("/testchmod", os.FileMode(0644))
 	if err != nil {
 		t.Error(err)
@@ -77,7 +85,7 @@ func TestChmod(t *testing.T) {
 }
 
 func TestChown(t *testing.T) {
-	fs.dirTree.new("testchown", Uid, Gid, os.FileMode(0644))
+	fs.dirTree.new(&fs.cred, "testchown", fs.cred.uid, fs.cred.gid, os.FileMode(0644))
 	err := fs.Chown("/testchown", 666, 777)
 	if err != nil {
 		t.Error(err)
@@ -88,8 +96,302 @@ func TestChown(t *testing.T) {
 	}
 }
 
+func TestChownRules(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	alice, bob := tfs.As(100, 100, 300), tfs.As(200, 200)
+
+	tfs.dirTree.new(&tfs.cred, "file", 100, 100, os.FileMode(0666))
+	in := tfs.dirTree.children["file"]
+
+	checkOwner := func(uid, gid uint32) {
+		t.Helper()
+		if in.uid != uid || in.gid != gid {
+			t.Errorf("Bad ownership %d:%d, want %d:%d", in.uid, in.gid, uid, gid)
+		}
+	}
+
+	// The owner may not give the file away, even to someone with access
+	for _, ids := range [][2]int{{200, -1}, {-1, 200}, {200, 200}} {
+		err := alice.Chown("/file", ids[0], ids[1])
+		if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+			t.Error("Bad error status", ids, err)
+		}
+	}
+
+	err := bob.Chown("/file", 200, 200)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+	checkOwner(100, 100)
+
+	// Changing nothing needs no ownership, unless it clears setuid
+	if err := bob.Chown("/file", -1, -1); err != nil {
+		t.Error(err)
+	}
+
+	in.mode |= os.ModeSetuid
+	err = bob.Chown("/file", -1, -1)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+	in.mode &^= os.ModeSetuid
+
+	// But may move it between their own groups, and leave it with themselves
+	if err := alice.Chown("/file", -1, 300); err != nil {
+		t.Error(err)
+	}
+	checkOwner(100, 300)
+
+	if err := alice.Chown("/file", 100, 100); err != nil {
+		t.Error(err)
+	}
+	checkOwner(100, 100)
+
+	// Root may do anything, with -1 leaving an id alone
+	if err := tfs.Chown("/file", 100000, -1); err != nil {
+		t.Error(err)
+	}
+	checkOwner(100000, 100)
+
+	if err := tfs.Chown("/file", -1, 200000); err != nil {
+		t.Error(err)
+	}
+	checkOwner(100000, 200000)
+
+	fi, err := tfs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*Stat_t); st.Uid != 100000 || st.Gid != 200000 {
+		t.Error("Bad ownership in Stat", st.Uid, st.Gid)
+	}
+
+	for _, id := range []int{-2, math.MinInt32} {
+		err := tfs.Chown("/file", id, id)
+		if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EINVAL {
+			t.Error("Bad error status", id, err)
+		}
+	}
+	checkOwner(100000, 200000)
+}
+
+func TestChmodOwner(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	alice, bob := tfs.As(100, 100), tfs.As(200, 200, 300)
+
+	tfs.dirTree.new(&tfs.cred, "file", 100, 300, os.FileMode(0666))
+	in := tfs.dirTree.children["file"]
+
+	// Only the owner or root may change the mode, whatever the permissions
+	err := bob.Chmod("/file", os.FileMode(0777))
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	err = bob.Chown("/file", 200, 200)
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	// The owner loses setgid when they are not in the group
+	err = alice.Chmod("/file", os.FileMode(0755)|os.ModeSetuid|os.ModeSetgid)
+	if err != nil {
+		t.Error(err)
+	}
+	if in.mode != os.FileMode(0755)|os.ModeSetuid {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	err = tfs.Chmod("/file", os.FileMode(0755)|os.ModeSetuid|os.ModeSetgid)
+	if err != nil {
+		t.Error(err)
+	}
+	if in.mode != os.FileMode(0755)|os.ModeSetuid|os.ModeSetgid {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	// Changing the owner clears setuid and setgid, even for root
+	err = tfs.Chown("/file", 100, 300)
+	if err != nil {
+		t.Error(err)
+	}
+	if in.mode != os.FileMode(0755) {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	// Unless setgid marks mandatory locking
+	in.mode = os.FileMode(0644) | os.ModeSetgid
+	err = alice.Chown("/file", 100, 300)
+	if err != nil {
+		t.Error(err)
+	}
+	if in.mode != os.FileMode(0644)|os.ModeSetgid {
+		t.Error("Bad file mode", in.mode)
+	}
+
+	// A directory keeps its bits
+	tfs.Mkdir("/dir", os.FileMode(0755)|os.ModeSticky)
+	tfs.Chmod("/dir", os.FileMode(0755)|os.ModeSetgid)
+	err = tfs.Chown("/dir", 100, 100)
+	if err != nil {
+		t.Error(err)
+	}
+	if tfs.dirTree.children["dir"].mode != os.FileMode(0755)|os.ModeDir|os.ModeSetgid {
+		t.Error("Bad file mode", tfs.dirTree.children["dir"].mode)
+	}
+}
+
+func TestSticky(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	alice, bob, carol := tfs.As(100, 100), tfs.As(200, 200), tfs.As(300, 300)
+
+	tfs.Mkdir("/tmp", 0)
+	tfs.Chmod("/tmp", os.FileMode(0777)|os.ModeSticky)
+	tfs.Chown("/tmp", 300, 300)
+
+	for _, name := range []string{"/tmp/a", "/tmp/b", "/tmp/c"} {
+		f, err := alice.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	// Write access to the directory is not enough to remove an entry
+	err := bob.Remove("/tmp/a")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	err = bob.Rename("/tmp/a", "/tmp/z")
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	f, err := bob.Create("/tmp/z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Nor to replace one
+	err = bob.Rename("/tmp/z", "/tmp/a")
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	err = alice.Rename("/tmp/a", "/tmp/z")
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	// The owners of the entry and of the directory may
+	if err := alice.Rename("/tmp/a", "/tmp/y"); err != nil {
+		t.Error(err)
+	}
+	if err := alice.Remove("/tmp/b"); err != nil {
+		t.Error(err)
+	}
+	if err := carol.Remove("/tmp/c"); err != nil {
+		t.Error(err)
+	}
+	if err := bob.Remove("/tmp/y"); !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+	if err := bob.Remove("/tmp/z"); err != nil {
+		t.Error(err)
+	}
+	if err := tfs.Remove("/tmp/y"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChtimes(t *testing.T) {
+	fs.dirTree.new(&fs.cred, "testchtimes", 100, 100, os.FileMode(0666))
+
+	atime := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	mtime := time.Date(2015, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	err := fs.Chtimes("/testchtimes", atime, mtime)
+	if err != nil {
+		t.Error(err)
+	}
+
+	in := fs.dirTree.children["testchtimes"]
+	if !in.atime.Equal(atime) || !in.mtime.Equal(mtime) {
+		t.Error("Bad times")
+	}
+	if in.ctime.Equal(mtime) {
+		t.Error("Bad ctime")
+	}
+
+	// Only the owner may set explicit times, even with write access
+	err = fs.As(200, 200).Chtimes("/testchtimes", atime, atime)
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.As(100, 100).Chtimes("/testchtimes", atime, atime)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A zero time is left alone
+	err = fs.Chtimes("/testchtimes", time.Time{}, mtime)
+	if err != nil {
+		t.Error(err)
+	}
+	if !in.atime.Equal(atime) || !in.mtime.Equal(mtime) {
+		t.Error("Bad times", in.atime, in.mtime)
+	}
+
+	err = fs.Chtimes("/testchtimes", mtime, time.Time{})
+	if err != nil {
+		t.Error(err)
+	}
+	if !in.atime.Equal(mtime) || !in.mtime.Equal(mtime) {
+		t.Error("Bad times", in.atime, in.mtime)
+	}
+}
+
+func TestLchown(t *testing.T) {
+	err := fs.Mkdir("/testlchown", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Symlink("/testlchown", "/testlchownlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Lchown("/testlchownlink", 666, 777)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ln := fs.dirTree.children["testlchownlink"]
+	if ln.uid != 666 || ln.gid != 777 {
+		t.Error("Bad link ownership")
+	}
+
+	dir := fs.dirTree.children["testlchown"]
+	if dir.uid != fs.cred.uid || dir.gid != fs.cred.gid {
+		t.Error("Target ownership changed")
+	}
+
+	// Lchown on a regular file changes the file itself
+	err = fs.Lchown("/testlchown", 666, 777)
+	if err != nil {
+		t.Error(err)
+	}
+	if dir.uid != 666 || dir.gid != 777 {
+		t.Error("Bad ownership")
+	}
+}
+
 func TestLink(t *testing.T) {
-	fs.dirTree.new("src", Uid, Gid, os.FileMode(0644))
+	fs.dirTree.new(&fs.cred, "src", fs.cred.uid, fs.cred.gid, os.FileMode(0644))
 	err := fs.Link("/src", "/dst")
 	if err != nil {
 		t.Error(err)
@@ -114,15 +416,55 @@ func TestLink(t *testing.T) {
 	}
 }
 
+// Creating an entry checks search access, then whether the name is taken,
+// then write access, as Linux does.
+func TestCreateErrorOrder(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	user := tfs.As(100, 100)
+
+	for _, d := range []string{"/rx", "/rw"} {
+		tfs.Mkdir(d, os.FileMode(0777))
+		tfs.Mkdir(d+"/taken", os.FileMode(0777))
+		tfs.Chown(d, 100, 100)
+	}
+	tfs.Chmod("/rx", os.FileMode(0500))
+	tfs.Chmod("/rw", os.FileMode(0600))
+	tfs.Mkdir("/file", os.FileMode(0777))
+
+	for _, dir := range []struct {
+		name string
+		want syscall.Errno
+	}{
+		{"/rx", syscall.EEXIST},
+		{"/rw", syscall.EACCES},
+	} {
+		name := dir.name + "/taken"
+
+		err := user.Mkdir(name, os.FileMode(0755))
+		if pe, ok := err.(*os.PathError); !ok || pe.Err != dir.want {
+			t.Error("Bad mkdir error", name, err)
+		}
+
+		err = user.Symlink("/file", name)
+		if le, ok := err.(*os.LinkError); !ok || le.Err != dir.want {
+			t.Error("Bad symlink error", name, err)
+		}
+
+		err = user.Link("/file", name)
+		if le, ok := err.(*os.LinkError); !ok || le.Err != dir.want {
+			t.Error("Bad link error", name, err)
+		}
+	}
+}
+
 func TestReadlink(t *testing.T) {
 
-	err := fs.dirTree.new("testreadlink", Uid, Gid, os.FileMode(0644)|os.ModeSymlink)
+	err := fs.dirTree.new(&fs.cred, "testreadlink", fs.cred.uid, fs.cred.gid, os.FileMode(0644)|os.ModeSymlink)
 	if err != nil {
 		t.Error(err)
 	}
 
 	fs.dirTree.children["testreadlink"].relName = "/src"
-	fs.dirTree.children["testreadlink"].rel = new(inode)
 
 	res, err := fs.Readlink("/testreadlink")
 	if err != nil {
@@ -143,15 +485,11 @@ func TestRemove(t *testing.T) {
 	ref := fs.dirTree.children["testrm"]
 	ref.linkCount = 2
 
-	Uid = 20
-
-	err = fs.Remove("/testrm")
+	err = fs.As(20, 0).Remove("/testrm")
 	if !os.IsPermission(err) {
 		t.Error(err)
 	}
 
-	Uid = 0
-
 	err = fs.Remove("/testrm")
 	if err != nil {
 		t.Error(err)
@@ -172,7 +510,7 @@ func TestRemoveAll(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = fs.dirTree.children["testrmall"].new("second", Uid, Gid, os.FileMode(0600))
+	err = fs.dirTree.children["testrmall"].new(&fs.cred, "second", fs.cred.uid, fs.cred.gid, os.FileMode(0600))
 	if err != nil {
 		t.Error(err)
 	}
@@ -210,6 +548,230 @@ func TestRename(t *testing.T) {
 	}
 }
 
+// Move files and directories between directories, some nested in others,
+// from many goroutines at once while other goroutines create, remove and
+// look up entries in them.  Renames in opposite directions must not
+// deadlock, and nothing may be lost or duplicated.
+func TestRenameConcurrent(t *testing.T) {
+	mfs := NewTestFS(0, 0)
+
+	// Each directory has a child, so that some renames are between a
+	// directory and its descendant
+	var dirs []string
+	for n := 0; n < 4; n++ {
+		d := fmt.Sprintf("/d%d", n)
+		dirs = append(dirs, d, d+"/n")
+	}
+	for _, d := range dirs {
+		if err := mfs.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const workers, files, renames = 8, 8, 500
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Each worker moves its own files and one directory of its own, so it
+	// always knows where they are
+	for w := 0; w < workers; w++ {
+		loc := make([]string, files)
+		for f := range loc {
+			loc[f] = dirs[(w+f)%len(dirs)]
+			if _, err := mfs.Create(fmt.Sprintf("%s/f%d-%d", loc[f], w, f)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sub := fmt.Sprintf("t%d", w)
+		subLoc := dirs[w%len(dirs)]
+		if err := mfs.Mkdir(subLoc+"/"+sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+
+			for n := 0; n < renames; n++ {
+				f := r.Intn(files)
+				name := fmt.Sprintf("f%d-%d", w, f)
+				to := dirs[r.Intn(len(dirs))]
+				if to == loc[f] {
+					continue
+				}
+
+				err := mfs.Rename(loc[f]+"/"+name, to+"/"+name)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				loc[f] = to
+
+				// A directory can never be moved into itself
+				err = mfs.Rename(subLoc+"/"+sub, subLoc+"/"+sub+"/x")
+				if !errors.Is(err, syscall.EINVAL) {
+					t.Error("Moved a directory into itself:", err)
+				}
+
+				to = dirs[r.Intn(len(dirs))]
+				if to == subLoc {
+					continue
+				}
+				err = mfs.Rename(subLoc+"/"+sub, to+"/"+sub)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				subLoc = to
+			}
+		}(w)
+	}
+
+	// Churn and lookups in the same directories until the renames finish
+	var churn sync.WaitGroup
+
+	// A directory renamed within its parent, so that only the parent is
+	// locked, while a handle inside it looks up its path
+	if err := mfs.MkdirAll("/s/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	h := mfs.As(0, 0)
+	if err := h.Chdir("/s/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	churn.Add(2)
+	go func() {
+		defer churn.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if err := mfs.Rename("/s/a", "/s/b"); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := mfs.Rename("/s/b", "/s/a"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer churn.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if d, err := h.Getwd(); err != nil || d != "/s/a" && d != "/s/b" {
+				t.Error("Bad WD", d, err)
+				return
+			}
+		}
+	}()
+
+	for n, d := range dirs {
+		churn.Add(1)
+		go func(n int, d string) {
+			defer churn.Done()
+			h := mfs.As(0, 0)
+			if err := h.Chdir(d); err != nil {
+				t.Error(err)
+				return
+			}
+
+			tmp := fmt.Sprintf("%s/tmp%d", d, n)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				if err := mfs.Mkdir(tmp, 0755); err != nil {
+					t.Error(err)
+					return
+				}
+				mfs.Create(tmp + "/file")
+				mfs.RemoveAll(tmp)
+
+				if f, err := mfs.Open(d); err == nil {
+					f.Readdir(-1)
+					f.Close()
+				}
+				if _, err := h.Getwd(); err != nil {
+					t.Error(err)
+				}
+			}
+		}(n, d)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stop)
+		churn.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("Renames deadlocked")
+	}
+
+	// Every file and directory is in exactly one place, under its own name
+	seen := make(map[string]int)
+	for _, d := range dirs {
+		f, err := mfs.Open(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range fi {
+			seen[e.Name()]++
+			in, err := mfs.lfind(d + "/" + e.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if in.Name() != e.Name() {
+				t.Error("Bad name", in.Name(), "for", e.Name())
+			}
+			if e.IsDir() && e.Name() != "n" {
+				p, err := in.path()
+				if err != nil || p != d+"/"+e.Name() {
+					t.Error("Bad path", p, err)
+				}
+			}
+		}
+	}
+
+	for w := 0; w < workers; w++ {
+		for f := 0; f < files; f++ {
+			if name := fmt.Sprintf("f%d-%d", w, f); seen[name] != 1 {
+				t.Error(name, "found", seen[name], "times")
+			}
+		}
+		if name := fmt.Sprintf("t%d", w); seen[name] != 1 {
+			t.Error(name, "found", seen[name], "times")
+		}
+	}
+}
+
 func TestSymlink(t *testing.T) {
 	err := fs.Mkdir("/testsymlink", os.FileMode(0755))
 	if err != nil {
@@ -226,9 +788,156 @@ func TestSymlink(t *testing.T) {
 		t.Fatal("Internal test error")
 	}
 
-	if ln.relName != "/testsymlink" || ln.rel != fs.dirTree.children["testsymlink"] || ln.mode&os.ModeSymlink == 0 {
+	if ln.relName != "/testsymlink" || ln.mode&os.ModeSymlink == 0 {
 		t.Error("Bad link data")
 	}
+
+	in, err := fs.find("/testlns")
+	if err != nil {
+		t.Error(err)
+	}
+	if in != fs.dirTree.children["testsymlink"] {
+		t.Error("Bad link target")
+	}
+
+	// Write and search access to the directory are enough
+	fs.Mkdir("/testsymlinkwx", os.FileMode(0777))
+	fs.Chmod("/testsymlinkwx", os.FileMode(0300))
+	fs.Chown("/testsymlinkwx", 100, 100)
+	err = fs.As(100, 100).Symlink("/testsymlink", "/testsymlinkwx/ln")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSymlinkDangling(t *testing.T) {
+	err := fs.Mkdir("/testdangling", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Symlink("/testdangling/target", "/testdangling/link")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = fs.Stat("/testdangling/link")
+	if !os.IsNotExist(err) {
+		t.Error("Bad error status", err)
+	}
+
+	fi, err := fs.Lstat("/testdangling/link")
+	if err != nil {
+		t.Error(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("Bad mode")
+	}
+	if fi.Size() != int64(len("/testdangling/target")) {
+		t.Error("Bad size")
+	}
+
+	// Creating the target makes the link work
+	f, err := fs.Create("/testdangling/target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	in, err := fs.find("/testdangling/link")
+	if err != nil {
+		t.Error(err)
+	}
+	if in != fs.dirTree.children["testdangling"].children["target"] {
+		t.Error("Bad link target")
+	}
+
+	// Replacing the target is seen through the link
+	err = fs.Remove("/testdangling/target")
+	if err != nil {
+		t.Error(err)
+	}
+	err = fs.Mkdir("/testdangling/target", os.FileMode(0755))
+	if err != nil {
+		t.Error(err)
+	}
+
+	fi, err = fs.Stat("/testdangling/link")
+	if err != nil {
+		t.Error(err)
+	}
+	if !fi.IsDir() {
+		t.Error("Link not updated to new target")
+	}
+
+	// Removing the link leaves the target alone
+	err = fs.Remove("/testdangling/link")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = fs.find("/testdangling/target")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSymlinkRelative(t *testing.T) {
+	err := fs.MkdirAll("/testrelative/a/b", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative targets are resolved against the directory of the link,
+	// not the working directory.
+	err = fs.Symlink("b", "/testrelative/a/link")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Symlink("a/link", "/testrelative/chain")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Symlink("../../a", "/testrelative/a/b/up")
+	if err != nil {
+		t.Error(err)
+	}
+
+	b := fs.dirTree.children["testrelative"].children["a"].children["b"]
+
+	in, err := fs.find("/testrelative/a/link")
+	if err != nil {
+		t.Error(err)
+	}
+	if in != b {
+		t.Error("Bad link target")
+	}
+
+	// Links to links, and links in the middle of a path
+	in, err = fs.find("/testrelative/chain/up/b")
+	if err != nil {
+		t.Error(err)
+	}
+	if in != b {
+		t.Error("Bad link target")
+	}
+
+	err = fs.MkdirAll("/testrelative/chain/c", os.FileMode(0755))
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := b.children["c"]; !ok {
+		t.Error("MkdirAll did not follow symlink")
+	}
+
+	res, err := fs.Readlink("/testrelative/chain")
+	if err != nil {
+		t.Error(err)
+	}
+	if res != "a/link" {
+		t.Error("Bad link data", res)
+	}
 }
 
 func TestStat(t *testing.T) {
@@ -248,12 +957,13 @@ func TestStat(t *testing.T) {
 
 	err = fs.Symlink("/teststat/test", "/teststat/link")
 
+	// As with os, the name is the one given even when a link is followed
 	fi, err = fs.Stat("/teststat/link")
 	if err != nil {
 		t.Error(err)
 	}
 
-	if fi.Name() != "test" {
+	if fi.Name() != "link" || !fi.IsDir() {
 		t.Error("Bad name")
 	}
 }
@@ -308,3 +1018,64 @@ func TestSys(t *testing.T)  {
     }
     
 }
+
+func TestTimes(t *testing.T) {
+	f, err := fs.Create("/testtimes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	in := f.(*file).inode
+	if in.btime.IsZero() || in.atime != in.btime || in.mtime != in.btime || in.ctime != in.btime {
+		t.Error("Bad initial times")
+	}
+
+	created := in.btime
+	time.Sleep(time.Millisecond)
+
+	f.Write([]byte("test data"))
+	if !in.mtime.After(created) || in.ctime != in.mtime || in.atime != created {
+		t.Error("Bad times after write")
+	}
+
+	written := in.mtime
+	time.Sleep(time.Millisecond)
+
+	f.ReadAt(make([]byte, 4), 0)
+	if !in.atime.After(written) || in.mtime != written || in.ctime != written {
+		t.Error("Bad times after read")
+	}
+
+	time.Sleep(time.Millisecond)
+
+	err = fs.Chmod("/testtimes", os.FileMode(0600))
+	if err != nil {
+		t.Error(err)
+	}
+	if !in.ctime.After(written) || in.mtime != written {
+		t.Error("Bad times after chmod")
+	}
+
+	changed := in.ctime
+	time.Sleep(time.Millisecond)
+
+	err = fs.Rename("/testtimes", "/testtimes2")
+	if err != nil {
+		t.Error(err)
+	}
+	if !in.ctime.After(changed) || in.mtime != written || in.btime != created {
+		t.Error("Bad times after rename")
+	}
+	if fs.dirTree.mtime != in.ctime || fs.dirTree.ctime != in.ctime {
+		t.Error("Bad directory times after rename")
+	}
+
+	st := in.Sys().(*Stat_t)
+	if st.Atime != in.atime || st.Ctime != in.ctime || st.Btime != in.btime {
+		t.Error("Bad Stat_t times")
+	}
+	if st.Mtim.Nano() != in.mtime.UnixNano() || st.Ctim.Nano() != in.ctime.UnixNano() {
+		t.Error("Bad Stat_t timespecs")
+	}
+}
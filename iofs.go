@@ -0,0 +1,235 @@
+package testfs
+
+import (
+	"io"
+	iofs "io/fs"
+	"path"
+	"sort"
+)
+
+// dirFS adapts a FileSystem to the io/fs interfaces, rooted at dir.
+type dirFS struct {
+	fsys FileSystem
+	dir  string
+}
+
+// DirFS returns an io/fs filesystem for the tree rooted at dir in fsys.  It
+// is the FileSystem equivalent of os.DirFS, and works with both TestFS and
+// OSFS.  The result implements fs.StatFS, fs.ReadDirFS, fs.ReadFileFS,
+// fs.GlobFS and fs.SubFS.
+func DirFS(fsys FileSystem, dir string) iofs.FS {
+	return &dirFS{fsys: fsys, dir: dir}
+}
+
+// join converts a slash-separated io/fs name into a path in the underlying
+// filesystem, returning an error if the name is not valid.
+func (d *dirFS) join(op, name string) (string, error) {
+	if !iofs.ValidPath(name) {
+		return "", &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+	if name == "." {
+		return d.dir, nil
+	}
+	return path.Join(d.dir, name), nil
+}
+
+// wrapErr rewrites an error from the underlying filesystem so that it
+// refers to the io/fs name rather than the full path.
+func wrapErr(op, name string, err error) error {
+	if pe, ok := err.(*iofs.PathError); ok {
+		err = pe.Err
+	}
+	return &iofs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (d *dirFS) Open(name string) (iofs.File, error) {
+	full, err := d.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := d.fsys.Open(full)
+	if err != nil {
+		return nil, wrapErr("open", name, err)
+	}
+
+	return &dirFile{f: f, name: name}, nil
+}
+
+func (d *dirFS) Stat(name string) (iofs.FileInfo, error) {
+	full, err := d.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := d.fsys.Stat(full)
+	if err != nil {
+		return nil, wrapErr("stat", name, err)
+	}
+
+	return namedInfo{fi, path.Base(name)}, nil
+}
+
+func (d *dirFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	full, err := d.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := d.fsys.Open(full)
+	if err != nil {
+		return nil, wrapErr("readdir", name, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Readdir(-1)
+	if err != nil {
+		return nil, wrapErr("readdir", name, err)
+	}
+
+	entries := make([]iofs.DirEntry, len(fi))
+	for i := range fi {
+		entries[i] = iofs.FileInfoToDirEntry(fi[i])
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (d *dirFS) ReadFile(name string) ([]byte, error) {
+	full, err := d.join("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := d.fsys.Open(full)
+	if err != nil {
+		return nil, wrapErr("readfile", name, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, wrapErr("readfile", name, err)
+	}
+
+	return data, nil
+}
+
+func (d *dirFS) Glob(pattern string) ([]string, error) {
+	// Hide our own Glob method from fs.Glob so it uses ReadDir instead
+	// of calling back into us.
+	return iofs.Glob(readDirOnly{d}, pattern)
+}
+
+func (d *dirFS) Sub(dir string) (iofs.FS, error) {
+	full, err := d.join("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "." {
+		return d, nil
+	}
+
+	return &dirFS{fsys: d.fsys, dir: full}, nil
+}
+
+// readDirOnly exposes just Open and ReadDir of a dirFS.
+type readDirOnly struct {
+	d *dirFS
+}
+
+func (r readDirOnly) Open(name string) (iofs.File, error) {
+	return r.d.Open(name)
+}
+
+func (r readDirOnly) ReadDir(name string) ([]iofs.DirEntry, error) {
+	return r.d.ReadDir(name)
+}
+
+// namedInfo overrides the name reported by a FileInfo.  The name of an
+// inode is not always the name it was looked up by (hardlinks and
+// symlinks), but io/fs requires the base name of the path.
+type namedInfo struct {
+	iofs.FileInfo
+	name string
+}
+
+func (n namedInfo) Name() string {
+	return n.name
+}
+
+// dirFile wraps an open File to implement fs.File and fs.ReadDirFile.
+type dirFile struct {
+	f       File
+	name    string
+	entries []iofs.DirEntry // Remaining directory entries, once read
+	listed  bool            // Whether entries has been populated
+}
+
+func (d *dirFile) Stat() (iofs.FileInfo, error) {
+	fi, err := d.f.Stat()
+	if err != nil {
+		return nil, wrapErr("stat", d.name, err)
+	}
+	return namedInfo{fi, path.Base(d.name)}, nil
+}
+
+func (d *dirFile) Read(b []byte) (int, error) {
+	return d.f.Read(b)
+}
+
+func (d *dirFile) ReadAt(b []byte, off int64) (int, error) {
+	return d.f.ReadAt(b, off)
+}
+
+func (d *dirFile) Seek(offset int64, whence int) (int64, error) {
+	return d.f.Seek(offset, whence)
+}
+
+func (d *dirFile) Close() error {
+	return d.f.Close()
+}
+
+// ReadDir reads the whole directory on first use and then hands out the
+// entries in order, following the fs.ReadDirFile contract.
+func (d *dirFile) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !d.listed {
+		fi, err := d.f.Readdir(-1)
+		if err != nil {
+			return nil, wrapErr("readdir", d.name, err)
+		}
+
+		d.entries = make([]iofs.DirEntry, len(fi))
+		for i := range fi {
+			d.entries[i] = iofs.FileInfoToDirEntry(fi[i])
+		}
+
+		sort.Slice(d.entries, func(i, j int) bool {
+			return d.entries[i].Name() < d.entries[j].Name()
+		})
+		d.listed = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,89 @@
+package testfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func populate(t *testing.T, testfs FileSystem, root string) {
+	err := testfs.MkdirAll(root+"/a/b/c", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testfs.Mkdir(root+"/empty", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"/one":        "first file",
+		"/a/two":      "second file",
+		"/a/b/three":  "",
+		"/a/b/c/four": "fourth file, with a little more data in it",
+	}
+
+	for name, data := range files {
+		f, err := testfs.Create(root + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString(data)
+		if err != nil {
+			t.Error(err)
+		}
+		f.Close()
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+	populate(t, testfs, "/iofs")
+
+	err := fstest.TestFS(DirFS(testfs, "/iofs"), "one", "a/two", "a/b/three", "a/b/c/four", "empty")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirFSRoot(t *testing.T) {
+	testfs := NewTestFS(0, 0)
+	populate(t, testfs, "")
+
+	err := fstest.TestFS(DirFS(testfs, "/"), "one", "a/two", "a/b/c/four")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirFSOS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testfs := NewOSFS()
+	populate(t, testfs, dir)
+
+	err = fstest.TestFS(DirFS(testfs, dir), "one", "a/two", "a/b/three", "a/b/c/four", "empty")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirFSInvalid(t *testing.T) {
+	fsys := DirFS(NewTestFS(0, 0), "/")
+
+	_, err := fsys.Open("/abs")
+	if _, ok := err.(*os.PathError); !ok {
+		t.Error("Bad error type", err)
+	}
+
+	_, err = fsys.Open("missing")
+	if !os.IsNotExist(err) {
+		t.Error("Bad error status", err)
+	}
+}
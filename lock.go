@@ -0,0 +1,486 @@
+package testfs
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"syscall"
+)
+
+// Operations for Flock, as for flock(2).
+const (
+	LOCK_SH = 0x1 // Shared lock
+	LOCK_EX = 0x2 // Exclusive lock
+	LOCK_NB = 0x4 // Fail rather than wait for a conflicting lock
+	LOCK_UN = 0x8 // Release the lock
+)
+
+// Commands for FcntlFlock, as for fcntl(2) on Linux.  The OFD commands
+// take locks owned by the open file rather than by the process.
+const (
+	F_GETLK      = 5  // Find a lock which would conflict
+	F_SETLK      = 6  // Set or release a lock, failing on a conflict
+	F_SETLKW     = 7  // Set or release a lock, waiting for any conflict
+	F_OFD_GETLK  = 36 // F_GETLK for an open file description lock
+	F_OFD_SETLK  = 37 // F_SETLK for an open file description lock
+	F_OFD_SETLKW = 38 // F_SETLKW for an open file description lock
+)
+
+// Lock types for Flock_t.
+const (
+	F_RDLCK = 0 // Shared lock
+	F_WRLCK = 1 // Exclusive lock
+	F_UNLCK = 2 // No lock
+)
+
+// Flock_t describes a byte-range lock for FcntlFlock, as struct flock does
+// for fcntl(2).
+type Flock_t struct {
+	Type   int16 // F_RDLCK, F_WRLCK or F_UNLCK
+	Whence int16 // What Start is relative to, as for Seek
+	Start  int64
+	Len    int64 // A Len of 0 reaches the end of the file, however far it grows
+	Pid    int32 // Process holding a conflicting lock, or -1 for an OFD lock
+}
+
+// rangeLock is a byte-range lock on [start, end) of a file.  A process lock
+// is owned by the handle which set it, which stands for a process, and an
+// OFD lock by the open file.
+type rangeLock struct {
+	owner interface{} // *cred or *file
+	pid   int32
+	write bool
+	start int64
+	end   int64 // math.MaxInt64 for a lock to the end of the file
+}
+
+// fileLocks holds the locks on one inode.  flocks maps each open file with
+// a flock(2) lock to whether it is exclusive.  Both kinds of lock are kept
+// apart, as on Linux, so neither ever conflicts with the other.
+type fileLocks struct {
+	flocks  map[*file]bool
+	ranges  []rangeLock
+	changed chan struct{} // Closed and replaced whenever a lock is released
+}
+
+// lockWait records a process waiting for a lock held by another, for
+// deadlock detection.
+type lockWait struct {
+	owner   *cred
+	blocker *cred
+}
+
+// Unsafe.  Wake everything waiting for a lock on i.  The caller must hold
+// sb.locks.
+func (i *inode) locksChanged() {
+	if i.locks.changed != nil {
+		close(i.locks.changed)
+		i.locks.changed = nil
+	}
+}
+
+// Unsafe.  Wait for a lock on i to be released, or for ctx to be done.  The
+// caller must hold sb.locks, which is dropped while waiting.
+func (i *inode) waitLocks(ctx context.Context) error {
+	if i.locks.changed == nil {
+		i.locks.changed = make(chan struct{})
+	}
+	changed := i.locks.changed
+
+	i.sb.locks.Unlock()
+	defer i.sb.locks.Lock()
+
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Set, convert or release the flock(2) lock of f.  Converting a lock is not
+// atomic: as on Linux, the old lock is dropped first, and is lost if the
+// new one cannot be had.
+func (f *file) flock(ctx context.Context, how int) error {
+	i := f.inode
+	i.sb.locks.Lock()
+	defer i.sb.locks.Unlock()
+
+	var write bool
+
+	switch how &^ LOCK_NB {
+
+	case LOCK_UN:
+		if _, ok := i.locks.flocks[f]; ok {
+			delete(i.locks.flocks, f)
+			i.locksChanged()
+		}
+		return nil
+
+	case LOCK_SH:
+
+	case LOCK_EX:
+		write = true
+
+	default:
+		return syscall.EINVAL
+
+	}
+
+	if held, ok := i.locks.flocks[f]; ok {
+		if held == write {
+			return nil
+		}
+		delete(i.locks.flocks, f)
+		i.locksChanged()
+	}
+
+	for {
+		conflict := false
+		for other, excl := range i.locks.flocks {
+			if other != f && (excl || write) {
+				conflict = true
+				break
+			}
+		}
+
+		if !conflict {
+			break
+		}
+
+		if how&LOCK_NB != 0 {
+			return syscall.EWOULDBLOCK
+		}
+		if err := i.waitLocks(ctx); err != nil {
+			return err
+		}
+	}
+
+	if i.locks.flocks == nil {
+		i.locks.flocks = make(map[*file]bool)
+	}
+	i.locks.flocks[f] = write
+	return nil
+}
+
+// Find the range a Flock_t covers in f.
+func (f *file) lockRange(lk *Flock_t) (int64, int64, error) {
+	var base int64
+
+	switch int(lk.Whence) {
+
+	case io.SeekStart:
+
+	case io.SeekCurrent:
+		f.mu.Lock()
+		base = int64(f.pos)
+		f.mu.Unlock()
+
+	case io.SeekEnd:
+		base = f.inode.Size()
+
+	default:
+		return 0, 0, syscall.EINVAL
+
+	}
+
+	if lk.Start > 0 && base > math.MaxInt64-lk.Start {
+		return 0, 0, syscall.EOVERFLOW
+	}
+
+	start := base + lk.Start
+	end := int64(math.MaxInt64)
+
+	// Once start is known not to be negative, a negative length cannot
+	// overflow, and runs past the start of the file instead
+	if start < 0 {
+		return 0, 0, syscall.EINVAL
+	}
+
+	switch {
+
+	case lk.Len > 0:
+		// Linux checks the last byte, so a lock may end at the largest
+		// offset, which is the same as locking to the end of the file
+		if lk.Len-1 > math.MaxInt64-start {
+			return 0, 0, syscall.EOVERFLOW
+		}
+		if lk.Len-1 < math.MaxInt64-start {
+			end = start + lk.Len
+		}
+
+	case lk.Len < 0:
+		// A negative length covers the bytes before start
+		start, end = start+lk.Len, start
+		if start < 0 {
+			return 0, 0, syscall.EINVAL
+		}
+
+	}
+
+	return start, end, nil
+}
+
+// Unsafe.  Return the first lock on i held by someone other than owner
+// which conflicts with a lock on [start, end).  The caller must hold
+// sb.locks.
+func (i *inode) conflict(owner interface{}, write bool, start, end int64) *rangeLock {
+	for n := range i.locks.ranges {
+		l := &i.locks.ranges[n]
+		if l.owner != owner && l.start < end && start < l.end && (l.write || write) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Unsafe.  Replace the locks owner holds on [start, end) with l, or with
+// nothing if l is nil.  Neighbouring locks of the same type are merged, as
+// on Linux.  The caller must hold sb.locks.
+func (i *inode) setRange(owner interface{}, start, end int64, l *rangeLock) {
+	var ranges []rangeLock
+	released := false
+
+	for _, old := range i.locks.ranges {
+		if old.owner != owner || old.end <= start || end <= old.start {
+			ranges = append(ranges, old)
+			continue
+		}
+
+		// Keep the parts of the old lock either side of the new range
+		if old.start < start {
+			left := old
+			left.end = start
+			ranges = append(ranges, left)
+		}
+		if end < old.end {
+			right := old
+			right.start = end
+			ranges = append(ranges, right)
+		}
+
+		if l == nil || (old.write && !l.write) {
+			released = true
+		}
+	}
+
+	if l != nil {
+		merged := *l
+		kept := ranges[:0]
+		for _, old := range ranges {
+			if old.owner == owner && old.write == merged.write && old.start <= merged.end && merged.start <= old.end {
+				if old.start < merged.start {
+					merged.start = old.start
+				}
+				if old.end > merged.end {
+					merged.end = old.end
+				}
+				continue
+			}
+			kept = append(kept, old)
+		}
+		ranges = append(kept, merged)
+	}
+
+	i.locks.ranges = ranges
+	if released {
+		i.locksChanged()
+	}
+}
+
+// Unsafe.  Report whether owner waiting for blocker would complete a cycle
+// of processes each waiting for the next.  The caller must hold s.locks.
+func (s *superblock) deadlock(owner, blocker *cred) bool {
+	seen := make(map[*cred]bool)
+	next := []*cred{blocker}
+
+	for len(next) > 0 {
+		c := next[len(next)-1]
+		next = next[:len(next)-1]
+
+		if c == owner {
+			return true
+		}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+
+		for w := range s.waits {
+			if w.owner == c {
+				next = append(next, w.blocker)
+			}
+		}
+	}
+
+	return false
+}
+
+// Get, set or release a byte-range lock, as fcntl(2) does.  Process locks
+// are owned by the handle f was opened through, so every file opened
+// through one handle shares them.  Only process locks are checked for
+// deadlocks, as on Linux.
+func (f *file) fcntlFlock(ctx context.Context, cmd int, lk *Flock_t) error {
+	var owner interface{} = f.cred
+	pid := f.cred.pid
+
+	switch cmd {
+
+	case F_GETLK, F_SETLK, F_SETLKW:
+
+	case F_OFD_GETLK, F_OFD_SETLK, F_OFD_SETLKW:
+		if lk.Pid != 0 {
+			return syscall.EINVAL
+		}
+		owner, pid = f, -1
+
+	default:
+		return syscall.EINVAL
+
+	}
+
+	var write bool
+
+	switch lk.Type {
+
+	case F_RDLCK:
+		if cmd != F_GETLK && cmd != F_OFD_GETLK && !f.readable() {
+			return syscall.EBADF
+		}
+
+	case F_WRLCK:
+		if cmd != F_GETLK && cmd != F_OFD_GETLK && !f.writable() {
+			return syscall.EBADF
+		}
+		write = true
+
+	case F_UNLCK:
+		if cmd == F_GETLK || cmd == F_OFD_GETLK {
+			return syscall.EINVAL
+		}
+
+	default:
+		return syscall.EINVAL
+
+	}
+
+	start, end, err := f.lockRange(lk)
+	if err != nil {
+		return err
+	}
+
+	i := f.inode
+	i.sb.locks.Lock()
+	defer i.sb.locks.Unlock()
+
+	if cmd == F_GETLK || cmd == F_OFD_GETLK {
+		l := i.conflict(owner, write, start, end)
+		if l == nil {
+			lk.Type = F_UNLCK
+			return nil
+		}
+
+		lk.Type = F_RDLCK
+		if l.write {
+			lk.Type = F_WRLCK
+		}
+		lk.Whence = io.SeekStart
+		lk.Start = l.start
+		lk.Len = 0
+		if l.end != math.MaxInt64 {
+			lk.Len = l.end - l.start
+		}
+		lk.Pid = l.pid
+		return nil
+	}
+
+	if lk.Type == F_UNLCK {
+		i.setRange(owner, start, end, nil)
+		return nil
+	}
+
+	for {
+		l := i.conflict(owner, write, start, end)
+		if l == nil {
+			break
+		}
+
+		if cmd == F_SETLK || cmd == F_OFD_SETLK {
+			return syscall.EAGAIN
+		}
+
+		err := i.waitRange(ctx, owner, l.owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	i.setRange(owner, start, end, &rangeLock{
+		owner: owner,
+		pid:   pid,
+		write: write,
+		start: start,
+		end:   end,
+	})
+	return nil
+}
+
+// Unsafe.  Wait for the lock of blocker to change, failing with EDEADLK if
+// both are processes which would end up waiting for each other.  The caller
+// must hold sb.locks.
+func (i *inode) waitRange(ctx context.Context, owner, blocker interface{}) error {
+	c, ok := owner.(*cred)
+	b, bok := blocker.(*cred)
+	if !ok || !bok {
+		return i.waitLocks(ctx)
+	}
+
+	if i.sb.deadlock(c, b) {
+		return syscall.EDEADLK
+	}
+
+	w := &lockWait{owner: c, blocker: b}
+	i.sb.waits[w] = struct{}{}
+	defer delete(i.sb.waits, w)
+
+	return i.waitLocks(ctx)
+}
+
+// Drop the locks which go away when f is closed: its flock and OFD locks,
+// and every lock the process holds on the file, however it was set.
+func (f *file) releaseLocks() {
+	i := f.inode
+	i.sb.locks.Lock()
+	defer i.sb.locks.Unlock()
+
+	if _, ok := i.locks.flocks[f]; ok {
+		delete(i.locks.flocks, f)
+		i.locksChanged()
+	}
+
+	i.setRange(f, 0, math.MaxInt64, nil)
+	i.setRange(f.cred, 0, math.MaxInt64, nil)
+}
+
+func (f *file) Flock(ctx context.Context, how int) error {
+	if f == nil {
+		return os.ErrInvalid
+	}
+	if f.isClosed() {
+		return pathError("flock", f.name, syscall.EBADF)
+	}
+
+	return pathError("flock", f.name, f.flock(ctx, how))
+}
+
+func (f *file) FcntlFlock(ctx context.Context, cmd int, lk *Flock_t) error {
+	if f == nil {
+		return os.ErrInvalid
+	}
+	if f.isClosed() {
+		return pathError("fcntl", f.name, syscall.EBADF)
+	}
+
+	return pathError("fcntl", f.name, f.fcntlFlock(ctx, cmd, lk))
+}
@@ -0,0 +1,336 @@
+package testfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Open name twice, as two separate open files.
+func openTwice(t *testing.T, tfs *TestFS, name string) (File, File) {
+	f, err := tfs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := tfs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, g
+}
+
+func TestFlock(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	ctx := context.Background()
+
+	f, g := openTwice(t, tfs, "/testFlock")
+	defer g.Close()
+
+	if err := f.Flock(ctx, LOCK_SH); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Flock(ctx, LOCK_SH|LOCK_NB); err != nil {
+		t.Error("Shared locks conflict:", err)
+	}
+
+	err := g.Flock(ctx, LOCK_EX|LOCK_NB)
+	if !errors.Is(err, syscall.EWOULDBLOCK) {
+		t.Error("Bad error converting to exclusive:", err)
+	}
+
+	// The failed conversion lost the shared lock of g
+	if err := f.Flock(ctx, LOCK_EX|LOCK_NB); err != nil {
+		t.Error("Lock not dropped by failed conversion:", err)
+	}
+
+	if err := g.Flock(ctx, 0); !errors.Is(err, syscall.EINVAL) {
+		t.Error("Bad error for no operation:", err)
+	}
+
+	// A waiting lock is granted once the holder closes its file
+	got := make(chan error)
+	go func() {
+		got <- g.Flock(ctx, LOCK_EX)
+	}()
+
+	select {
+	case err := <-got:
+		t.Fatal("Lock granted while held:", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	f.Close()
+	if err := <-got; err != nil {
+		t.Error(err)
+	}
+
+	err = f.Flock(ctx, LOCK_UN)
+	if pe, ok := err.(*os.PathError); !ok || pe.Op != "flock" || pe.Err != syscall.EBADF {
+		t.Error("Bad error for closed file:", err)
+	}
+
+	// A wait gives up when its context is done
+	h, err := tfs.Open("/testFlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := h.Flock(timeout, LOCK_SH); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Bad error for cancelled wait:", err)
+	}
+
+	if err := g.Flock(ctx, LOCK_UN); err != nil {
+		t.Error(err)
+	}
+	if err := h.Flock(ctx, LOCK_SH|LOCK_NB); err != nil {
+		t.Error("Lock not released:", err)
+	}
+}
+
+// Return the lock f would conflict with on [start, start+n).
+func getlk(t *testing.T, f File, cmd int, typ int16, start, n int64) Flock_t {
+	lk := Flock_t{Type: typ, Start: start, Len: n}
+	if err := f.FcntlFlock(context.Background(), cmd, &lk); err != nil {
+		t.Fatal(err)
+	}
+	return lk
+}
+
+// Set a lock of type typ on [start, start+n) through f.
+func setlk(f File, cmd int, typ int16, start, n int64) error {
+	lk := Flock_t{Type: typ, Start: start, Len: n}
+	return f.FcntlFlock(context.Background(), cmd, &lk)
+}
+
+func TestFcntlFlock(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	other := tfs.As(0, 0)
+
+	f, g := openTwice(t, tfs, "/testFcntlFlock")
+	defer g.Close()
+
+	h, err := other.OpenFile("/testFcntlFlock", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if err := setlk(f, F_SETLK, F_WRLCK, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files opened through one handle belong to the same process
+	if err := setlk(g, F_SETLK, F_RDLCK, 15, 10); err != nil {
+		t.Error("Process conflicts with itself:", err)
+	}
+
+	// That replaced the middle of the write lock, and extended it with a
+	// read lock
+	lk := getlk(t, h, F_GETLK, F_RDLCK, 0, 0)
+	if lk.Type != F_WRLCK || lk.Start != 10 || lk.Len != 5 || lk.Pid != tfs.cred.pid {
+		t.Errorf("Bad conflicting lock %+v", lk)
+	}
+	if lk := getlk(t, h, F_GETLK, F_RDLCK, 15, 0); lk.Type != F_UNLCK {
+		t.Errorf("Read locks conflict: %+v", lk)
+	}
+	lk = getlk(t, h, F_GETLK, F_WRLCK, 15, 0)
+	if lk.Type != F_RDLCK || lk.Start != 15 || lk.Len != 10 {
+		t.Errorf("Bad conflicting lock %+v", lk)
+	}
+
+	if err := setlk(h, F_SETLK, F_WRLCK, 20, 1); !errors.Is(err, syscall.EAGAIN) {
+		t.Error("Bad error for conflict:", err)
+	}
+	if err := setlk(h, F_SETLK, F_WRLCK, 25, 0); err != nil {
+		t.Error(err)
+	}
+
+	// A lock to the end of the file is reported with a length of 0
+	lk = getlk(t, f, F_GETLK, F_RDLCK, 1000, 1)
+	if lk.Type != F_WRLCK || lk.Start != 25 || lk.Len != 0 || lk.Pid != other.cred.pid {
+		t.Errorf("Bad conflicting lock %+v", lk)
+	}
+
+	// Ranges may be relative to the offset or the end, and run backwards
+	f.WriteString("0123456789")
+	lk = Flock_t{Type: F_WRLCK, Whence: io.SeekCurrent, Start: -2, Len: -3}
+	if err := f.FcntlFlock(context.Background(), F_SETLK, &lk); err != nil {
+		t.Fatal(err)
+	}
+	if lk := getlk(t, h, F_GETLK, F_RDLCK, 0, 10); lk.Start != 5 || lk.Len != 3 {
+		t.Errorf("Bad lock set relative to offset %+v", lk)
+	}
+
+	lk = Flock_t{Type: F_RDLCK, Whence: io.SeekEnd, Start: -11}
+	if err := f.FcntlFlock(context.Background(), F_SETLK, &lk); !errors.Is(err, syscall.EINVAL) {
+		t.Error("Bad error for lock before the start:", err)
+	}
+
+	// A range past the largest offset overflows rather than wrapping
+	lk = Flock_t{Type: F_WRLCK, Start: 10, Len: math.MaxInt64}
+	if err := f.FcntlFlock(context.Background(), F_SETLK, &lk); !errors.Is(err, syscall.EOVERFLOW) {
+		t.Error("Bad error for overflowing length:", err)
+	}
+	lk = Flock_t{Type: F_WRLCK, Whence: io.SeekEnd, Start: math.MaxInt64}
+	if err := f.FcntlFlock(context.Background(), F_SETLK, &lk); !errors.Is(err, syscall.EOVERFLOW) {
+		t.Error("Bad error for overflowing start:", err)
+	}
+	if lk := getlk(t, h, F_GETLK, F_WRLCK, 1000, 0); lk.Type != F_UNLCK {
+		t.Errorf("Overflowing lock was set %+v", lk)
+	}
+
+	// But a range may end at the largest offset, which runs to the end
+	if err := setlk(h, F_SETLK, F_WRLCK, math.MaxInt64, 1); err != nil {
+		t.Error("Lock of the last byte refused:", err)
+	}
+	lk = getlk(t, f, F_GETLK, F_RDLCK, 1, math.MaxInt64)
+	if lk.Type != F_WRLCK || lk.Start != 25 || lk.Len != 0 {
+		t.Errorf("Bad conflicting lock %+v", lk)
+	}
+
+	for _, typ := range []int16{F_UNLCK, 3} {
+		lk = Flock_t{Type: typ}
+		if err := f.FcntlFlock(context.Background(), F_GETLK, &lk); !errors.Is(err, syscall.EINVAL) {
+			t.Error("Bad error for F_GETLK of type", typ, err)
+		}
+	}
+
+	// Closing any file of a process drops every lock it holds on the file
+	f.Close()
+	if lk := getlk(t, h, F_GETLK, F_WRLCK, 0, 25); lk.Type != F_UNLCK {
+		t.Errorf("Locks kept after close %+v", lk)
+	}
+
+	// The access mode must allow the type of lock
+	r, err := tfs.Open("/testFcntlFlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := setlk(r, F_SETLK, F_WRLCK, 0, 1); !errors.Is(err, syscall.EBADF) {
+		t.Error("Bad error for write lock on read only file:", err)
+	}
+	if lk := getlk(t, r, F_GETLK, F_WRLCK, 0, 0); lk.Type != F_WRLCK {
+		t.Errorf("Bad conflicting lock %+v", lk)
+	}
+}
+
+func TestFcntlFlockOFD(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+
+	f, g := openTwice(t, tfs, "/testFcntlFlockOFD")
+	defer g.Close()
+
+	if err := setlk(f, F_OFD_SETLK, F_WRLCK, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// OFD locks belong to the open file, so they conflict within a process
+	if err := setlk(g, F_OFD_SETLK, F_RDLCK, 5, 1); !errors.Is(err, syscall.EAGAIN) {
+		t.Error("Bad error for conflict:", err)
+	}
+	if err := setlk(g, F_SETLK, F_RDLCK, 5, 1); !errors.Is(err, syscall.EAGAIN) {
+		t.Error("Process lock does not conflict with OFD lock:", err)
+	}
+
+	lk := getlk(t, g, F_OFD_GETLK, F_RDLCK, 0, 0)
+	if lk.Type != F_WRLCK || lk.Start != 0 || lk.Len != 10 || lk.Pid != -1 {
+		t.Errorf("Bad conflicting lock %+v", lk)
+	}
+
+	lk = Flock_t{Type: F_RDLCK, Pid: 1}
+	if err := g.FcntlFlock(context.Background(), F_OFD_SETLK, &lk); !errors.Is(err, syscall.EINVAL) {
+		t.Error("Bad error for OFD lock with pid:", err)
+	}
+
+	// Only closing the file which holds an OFD lock releases it
+	h, err := tfs.Open("/testFcntlFlockOFD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+	if err := setlk(g, F_OFD_SETLK, F_RDLCK, 5, 1); err == nil {
+		t.Error("OFD lock dropped by closing another file")
+	}
+
+	got := make(chan error)
+	go func() {
+		lk := Flock_t{Type: F_WRLCK}
+		got <- g.FcntlFlock(context.Background(), F_OFD_SETLKW, &lk)
+	}()
+
+	select {
+	case err := <-got:
+		t.Fatal("Lock granted while held:", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	f.Close()
+	if err := <-got; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFcntlFlockWait(t *testing.T) {
+	tfs := NewTestFS(0, 0)
+	a, b := tfs.As(0, 0), tfs.As(0, 0)
+
+	f, err := a.Create("/testFcntlFlockWait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, err := b.OpenFile("/testFcntlFlockWait", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	if err := setlk(f, F_SETLK, F_WRLCK, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := setlk(g, F_SETLK, F_WRLCK, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	lk := Flock_t{Type: F_WRLCK, Start: 0, Len: 1}
+	if err := g.FcntlFlock(timeout, F_SETLKW, &lk); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Bad error for cancelled wait:", err)
+	}
+
+	// b waits for a, so a waiting for b would never finish
+	got := make(chan error)
+	go func() {
+		got <- setlk(g, F_SETLKW, F_WRLCK, 0, 1)
+	}()
+
+	for waiting := 0; waiting == 0; {
+		time.Sleep(time.Millisecond)
+		tfs.sb.locks.Lock()
+		waiting = len(tfs.sb.waits)
+		tfs.sb.locks.Unlock()
+	}
+
+	if err := setlk(f, F_SETLKW, F_WRLCK, 1, 1); !errors.Is(err, syscall.EDEADLK) {
+		t.Error("Bad error for deadlock:", err)
+	}
+
+	if err := setlk(f, F_SETLK, F_UNLCK, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-got; err != nil {
+		t.Error(err)
+	}
+}
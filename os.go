@@ -2,6 +2,7 @@ package testfs
 
 import (
 	"os"
+	"time"
 )
 
 type osfs struct{}
@@ -23,6 +24,14 @@ func (o *osfs) Chown(name string, uid, gid int) error {
 	return os.Chown(name, uid, gid)
 }
 
+func (o *osfs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (o *osfs) Lchown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
+
 func (o *osfs) Link(oldname, newname string) error {
 	return os.Link(oldname, newname)
 }
@@ -64,15 +73,15 @@ func (o *osfs) Truncate(name string, size int64) error {
 }
 
 func (o *osfs) Create(name string) (file File, err error) {
-	return os.Create(name)
+	return wrapFile(os.Create(name))
 }
 
 func (o *osfs) Open(name string) (file File, err error) {
-	return os.Open(name)
+	return wrapFile(os.Open(name))
 }
 
 func (o *osfs) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
-	return os.OpenFile(name, flag, perm)
+	return wrapFile(os.OpenFile(name, flag, perm))
 }
 
 func (o *osfs) Lstat(path string) (os.FileInfo, error) {
@@ -81,4 +90,18 @@ func (o *osfs) Lstat(path string) (os.FileInfo, error) {
 
 func (o *osfs) Stat(path string) (os.FileInfo, error) {
 	return os.Stat(path)
-}
\ No newline at end of file
+}
+
+// osFile extends os.File with the extended attribute methods of File.
+type osFile struct {
+	*os.File
+}
+
+// Wrap the result of an os open function, taking care not to return a
+// non-nil File holding a nil *os.File.
+func wrapFile(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{f}, nil
+}
@@ -0,0 +1,101 @@
+package testfs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// The kernel cannot be asked to give up a blocking lock, so a wait which
+// may be cancelled polls instead, trying again this often.  Polling waits
+// do not get EDEADLK, as only a blocking wait is checked for deadlock.
+const lockPollInterval = 10 * time.Millisecond
+
+// Call try until it stops failing with EAGAIN, or ctx is done.
+func pollLock(ctx context.Context, try func() error) error {
+	for {
+		err := try()
+		if err != syscall.EAGAIN {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// Call fn again for as long as a signal interrupts it.
+func ignoringEINTR(fn func() error) error {
+	for {
+		err := fn()
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func (f *osFile) Flock(ctx context.Context, how int) error {
+	fd := int(f.Fd())
+
+	var err error
+	if how&LOCK_NB != 0 || ctx.Done() == nil {
+		err = ignoringEINTR(func() error {
+			return syscall.Flock(fd, how)
+		})
+	} else {
+		err = pollLock(ctx, func() error {
+			return syscall.Flock(fd, how|LOCK_NB)
+		})
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+func (f *osFile) FcntlFlock(ctx context.Context, cmd int, lk *Flock_t) error {
+	sl := syscall.Flock_t{
+		Type:   lk.Type,
+		Whence: lk.Whence,
+		Start:  lk.Start,
+		Len:    lk.Len,
+		Pid:    lk.Pid,
+	}
+	fd := f.Fd()
+
+	var err error
+	switch {
+
+	case cmd == F_SETLKW && ctx.Done() != nil:
+		err = pollLock(ctx, func() error {
+			return syscall.FcntlFlock(fd, F_SETLK, &sl)
+		})
+
+	case cmd == F_OFD_SETLKW && ctx.Done() != nil:
+		err = pollLock(ctx, func() error {
+			return syscall.FcntlFlock(fd, F_OFD_SETLK, &sl)
+		})
+
+	default:
+		err = ignoringEINTR(func() error {
+			return syscall.FcntlFlock(fd, cmd, &sl)
+		})
+
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "fcntl", Path: f.Name(), Err: err}
+	}
+
+	lk.Type = sl.Type
+	lk.Whence = sl.Whence
+	lk.Start = sl.Start
+	lk.Len = sl.Len
+	lk.Pid = sl.Pid
+	return nil
+}
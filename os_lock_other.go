@@ -0,0 +1,19 @@
+//go:build !linux
+
+package testfs
+
+import (
+	"context"
+	"os"
+	"syscall"
+)
+
+// File locks are only implemented for OSFS on Linux.
+
+func (f *osFile) Flock(ctx context.Context, how int) error {
+	return &os.PathError{Op: "flock", Path: f.Name(), Err: syscall.ENOTSUP}
+}
+
+func (f *osFile) FcntlFlock(ctx context.Context, cmd int, lk *Flock_t) error {
+	return &os.PathError{Op: "fcntl", Path: f.Name(), Err: syscall.ENOTSUP}
+}
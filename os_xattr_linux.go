@@ -0,0 +1,284 @@
+package testfs
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The syscall package lacks the l* and f* xattr calls, so they are made
+// directly here.  Each get or list call is made twice: once to find the
+// size of the result, and once to fetch it.  If the value grows in between
+// we get ERANGE, and start again.
+
+func pathXattr(trap uintptr, name, attr string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	a, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return 0, err
+	}
+
+	var d unsafe.Pointer
+	if len(dest) > 0 {
+		d = unsafe.Pointer(&dest[0])
+	}
+
+	n, _, errno := syscall.Syscall6(trap, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(d), uintptr(len(dest)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func fdXattr(trap uintptr, fd uintptr, attr string, dest []byte) (int, error) {
+	a, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return 0, err
+	}
+
+	var d unsafe.Pointer
+	if len(dest) > 0 {
+		d = unsafe.Pointer(&dest[0])
+	}
+
+	n, _, errno := syscall.Syscall6(trap, fd, uintptr(unsafe.Pointer(a)), uintptr(d), uintptr(len(dest)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func pathList(trap uintptr, name string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+
+	var d unsafe.Pointer
+	if len(dest) > 0 {
+		d = unsafe.Pointer(&dest[0])
+	}
+
+	n, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(p)), uintptr(d), uintptr(len(dest)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func fdList(fd uintptr, dest []byte) (int, error) {
+	var d unsafe.Pointer
+	if len(dest) > 0 {
+		d = unsafe.Pointer(&dest[0])
+	}
+
+	n, _, errno := syscall.Syscall(syscall.SYS_FLISTXATTR, fd, uintptr(d), uintptr(len(dest)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func pathSet(trap uintptr, name, attr string, data []byte, flags int) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	a, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+
+	var d unsafe.Pointer
+	if len(data) > 0 {
+		d = unsafe.Pointer(&data[0])
+	}
+
+	_, _, errno := syscall.Syscall6(trap, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(d), uintptr(len(data)), uintptr(flags), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func pathRemove(trap uintptr, name, attr string) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	a, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Repeatedly call get, a sized xattr fetch, until the result fits.
+func sized(get func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := get(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, n)
+		if n == 0 {
+			return buf, nil
+		}
+
+		n, err = get(buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	}
+}
+
+// Split a NUL separated list of attribute names.
+func splitNames(buf []byte) []string {
+	names := strings.Split(string(buf), "\x00")
+	if len(names) > 0 && names[len(names)-1] == "" {
+		names = names[:len(names)-1]
+	}
+	return names
+}
+
+func xattrError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func (o *osfs) Getxattr(name, attr string) ([]byte, error) {
+	data, err := sized(func(dest []byte) (int, error) {
+		return pathXattr(syscall.SYS_GETXATTR, name, attr, dest)
+	})
+	if err != nil {
+		return nil, xattrError("getxattr", name, err)
+	}
+	return data, nil
+}
+
+func (o *osfs) Lgetxattr(name, attr string) ([]byte, error) {
+	data, err := sized(func(dest []byte) (int, error) {
+		return pathXattr(syscall.SYS_LGETXATTR, name, attr, dest)
+	})
+	if err != nil {
+		return nil, xattrError("lgetxattr", name, err)
+	}
+	return data, nil
+}
+
+func (o *osfs) Setxattr(name, attr string, data []byte, flags int) error {
+	err := pathSet(syscall.SYS_SETXATTR, name, attr, data, flags)
+	if err != nil {
+		return xattrError("setxattr", name, err)
+	}
+	return nil
+}
+
+func (o *osfs) Lsetxattr(name, attr string, data []byte, flags int) error {
+	err := pathSet(syscall.SYS_LSETXATTR, name, attr, data, flags)
+	if err != nil {
+		return xattrError("lsetxattr", name, err)
+	}
+	return nil
+}
+
+func (o *osfs) Listxattr(name string) ([]string, error) {
+	buf, err := sized(func(dest []byte) (int, error) {
+		return pathList(syscall.SYS_LISTXATTR, name, dest)
+	})
+	if err != nil {
+		return nil, xattrError("listxattr", name, err)
+	}
+	return splitNames(buf), nil
+}
+
+func (o *osfs) Llistxattr(name string) ([]string, error) {
+	buf, err := sized(func(dest []byte) (int, error) {
+		return pathList(syscall.SYS_LLISTXATTR, name, dest)
+	})
+	if err != nil {
+		return nil, xattrError("llistxattr", name, err)
+	}
+	return splitNames(buf), nil
+}
+
+func (o *osfs) Removexattr(name, attr string) error {
+	err := pathRemove(syscall.SYS_REMOVEXATTR, name, attr)
+	if err != nil {
+		return xattrError("removexattr", name, err)
+	}
+	return nil
+}
+
+func (o *osfs) Lremovexattr(name, attr string) error {
+	err := pathRemove(syscall.SYS_LREMOVEXATTR, name, attr)
+	if err != nil {
+		return xattrError("lremovexattr", name, err)
+	}
+	return nil
+}
+
+func (f *osFile) Getxattr(attr string) ([]byte, error) {
+	data, err := sized(func(dest []byte) (int, error) {
+		return fdXattr(syscall.SYS_FGETXATTR, f.Fd(), attr, dest)
+	})
+	if err != nil {
+		return nil, xattrError("fgetxattr", f.Name(), err)
+	}
+	return data, nil
+}
+
+func (f *osFile) Setxattr(attr string, data []byte, flags int) error {
+	a, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return xattrError("fsetxattr", f.Name(), err)
+	}
+
+	var d unsafe.Pointer
+	if len(data) > 0 {
+		d = unsafe.Pointer(&data[0])
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_FSETXATTR, f.Fd(), uintptr(unsafe.Pointer(a)), uintptr(d), uintptr(len(data)), uintptr(flags), 0)
+	if errno != 0 {
+		return xattrError("fsetxattr", f.Name(), errno)
+	}
+	return nil
+}
+
+func (f *osFile) Listxattr() ([]string, error) {
+	buf, err := sized(func(dest []byte) (int, error) {
+		return fdList(f.Fd(), dest)
+	})
+	if err != nil {
+		return nil, xattrError("flistxattr", f.Name(), err)
+	}
+	return splitNames(buf), nil
+}
+
+func (f *osFile) Removexattr(attr string) error {
+	a, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return xattrError("fremovexattr", f.Name(), err)
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_FREMOVEXATTR, f.Fd(), uintptr(unsafe.Pointer(a)), 0)
+	if errno != 0 {
+		return xattrError("fremovexattr", f.Name(), errno)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !linux
+
+package testfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Extended attributes are only implemented for OSFS on Linux.
+
+func xattrError(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: syscall.ENOTSUP}
+}
+
+func (o *osfs) Getxattr(name, attr string) ([]byte, error) {
+	return nil, xattrError("getxattr", name)
+}
+
+func (o *osfs) Lgetxattr(name, attr string) ([]byte, error) {
+	return nil, xattrError("lgetxattr", name)
+}
+
+func (o *osfs) Setxattr(name, attr string, data []byte, flags int) error {
+	return xattrError("setxattr", name)
+}
+
+func (o *osfs) Lsetxattr(name, attr string, data []byte, flags int) error {
+	return xattrError("lsetxattr", name)
+}
+
+func (o *osfs) Listxattr(name string) ([]string, error) {
+	return nil, xattrError("listxattr", name)
+}
+
+func (o *osfs) Llistxattr(name string) ([]string, error) {
+	return nil, xattrError("llistxattr", name)
+}
+
+func (o *osfs) Removexattr(name, attr string) error {
+	return xattrError("removexattr", name)
+}
+
+func (o *osfs) Lremovexattr(name, attr string) error {
+	return xattrError("lremovexattr", name)
+}
+
+func (f *osFile) Getxattr(attr string) ([]byte, error) {
+	return nil, xattrError("fgetxattr", f.Name())
+}
+
+func (f *osFile) Setxattr(attr string, data []byte, flags int) error {
+	return xattrError("fsetxattr", f.Name())
+}
+
+func (f *osFile) Listxattr() ([]string, error) {
+	return nil, xattrError("flistxattr", f.Name())
+}
+
+func (f *osFile) Removexattr(attr string) error {
+	return xattrError("fremovexattr", f.Name())
+}
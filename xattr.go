@@ -0,0 +1,324 @@
+package testfs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Flags for Setxattr, as for setxattr(2).
+const (
+	XATTR_CREATE  = 0x1 // Fail if the attribute already exists
+	XATTR_REPLACE = 0x2 // Fail if the attribute does not exist
+)
+
+const (
+	xattrNameMax = 255   // Maximum length of an attribute name
+	xattrSizeMax = 65536 // Maximum size of an attribute value
+)
+
+// Check whether c may access the attribute attr on i, following the Linux
+// namespace rules.  write is true for set and remove.
+func (i *inode) xattrPerm(c *cred, attr string, write bool) error {
+	if len(attr) > xattrNameMax {
+		return syscall.ERANGE
+	}
+
+	ns := strings.SplitN(attr, ".", 2)
+	if len(ns) != 2 {
+		return syscall.ENOTSUP
+	}
+	if ns[1] == "" {
+		return syscall.EINVAL
+	}
+
+	i.attr.RLock()
+	mode, uid := i.mode, i.uid
+	i.attr.RUnlock()
+
+	switch ns[0] {
+
+	case "user":
+		// User attributes are only allowed on regular files and
+		// directories.
+		if !mode.IsRegular() && !mode.IsDir() {
+			if write {
+				return syscall.EPERM
+			}
+			return ENOATTR
+		}
+
+	case "trusted":
+		if c.uid != 0 {
+			if write {
+				return syscall.EPERM
+			}
+			return ENOATTR
+		}
+		return nil
+
+	case "security":
+		if write && c.uid != 0 {
+			return syscall.EPERM
+		}
+		return nil
+
+	case "system":
+		// The system namespace only holds POSIX ACLs, which the owner
+		// may set.
+		switch ns[1] {
+		case "posix_acl_access":
+		case "posix_acl_default":
+			if !mode.IsDir() {
+				if write {
+					return syscall.EACCES
+				}
+				return ENOATTR
+			}
+		default:
+			return syscall.ENOTSUP
+		}
+		if write && c.uid != 0 && c.uid != uid {
+			return syscall.EPERM
+		}
+		return nil
+
+	default:
+		return syscall.ENOTSUP
+
+	}
+
+	if write {
+		if !checkPerm(c, i, 'w') {
+			return syscall.EACCES
+		}
+	} else {
+		if !checkPerm(c, i, 'r') {
+			return syscall.EACCES
+		}
+	}
+
+	return nil
+}
+
+func (i *inode) getxattr(c *cred, attr string) ([]byte, error) {
+	if err := i.xattrPerm(c, attr, false); err != nil {
+		return nil, err
+	}
+
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+
+	val, ok := i.xattrs[attr]
+	if !ok {
+		return nil, ENOATTR
+	}
+
+	return []byte(val), nil
+}
+
+func (i *inode) setxattr(c *cred, attr string, data []byte, flags int) error {
+	if flags&^(XATTR_CREATE|XATTR_REPLACE) != 0 {
+		return syscall.EINVAL
+	}
+
+	if len(data) > xattrSizeMax {
+		return syscall.E2BIG
+	}
+
+	if err := i.xattrPerm(c, attr, true); err != nil {
+		return err
+	}
+
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	_, ok := i.xattrs[attr]
+
+	switch {
+
+	case ok && flags&XATTR_CREATE != 0:
+		return syscall.EEXIST
+
+	case !ok && flags&XATTR_REPLACE != 0:
+		return ENOATTR
+
+	}
+
+	i.xattrs[attr] = string(data)
+	i.ctime = i.sb.now()
+	return nil
+}
+
+func (i *inode) listxattr(c *cred) ([]string, error) {
+	i.attr.RLock()
+	defer i.attr.RUnlock()
+
+	names := make([]string, 0, len(i.xattrs))
+
+	for name := range i.xattrs {
+		// Trusted attributes are hidden from unprivileged users
+		if strings.HasPrefix(name, "trusted.") && c.uid != 0 {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (i *inode) removexattr(c *cred, attr string) error {
+	if err := i.xattrPerm(c, attr, true); err != nil {
+		return err
+	}
+
+	i.attr.Lock()
+	defer i.attr.Unlock()
+
+	if _, ok := i.xattrs[attr]; !ok {
+		return ENOATTR
+	}
+
+	delete(i.xattrs, attr)
+	i.ctime = i.sb.now()
+	return nil
+}
+
+func (t *TestFS) Getxattr(name, attr string) ([]byte, error) {
+	f, err := t.find(name)
+	if err != nil {
+		return nil, pathError("getxattr", name, err)
+	}
+
+	val, err := f.getxattr(&t.cred, attr)
+	if err != nil {
+		return nil, pathError("getxattr", name, err)
+	}
+
+	return val, nil
+}
+
+func (t *TestFS) Lgetxattr(name, attr string) ([]byte, error) {
+	f, err := t.lfind(name)
+	if err != nil {
+		return nil, pathError("lgetxattr", name, err)
+	}
+
+	val, err := f.getxattr(&t.cred, attr)
+	if err != nil {
+		return nil, pathError("lgetxattr", name, err)
+	}
+
+	return val, nil
+}
+
+func (t *TestFS) Setxattr(name, attr string, data []byte, flags int) error {
+	f, err := t.find(name)
+	if err != nil {
+		return pathError("setxattr", name, err)
+	}
+
+	return pathError("setxattr", name, f.setxattr(&t.cred, attr, data, flags))
+}
+
+func (t *TestFS) Lsetxattr(name, attr string, data []byte, flags int) error {
+	f, err := t.lfind(name)
+	if err != nil {
+		return pathError("lsetxattr", name, err)
+	}
+
+	return pathError("lsetxattr", name, f.setxattr(&t.cred, attr, data, flags))
+}
+
+func (t *TestFS) Listxattr(name string) ([]string, error) {
+	f, err := t.find(name)
+	if err != nil {
+		return nil, pathError("listxattr", name, err)
+	}
+
+	return f.listxattr(&t.cred)
+}
+
+func (t *TestFS) Llistxattr(name string) ([]string, error) {
+	f, err := t.lfind(name)
+	if err != nil {
+		return nil, pathError("llistxattr", name, err)
+	}
+
+	return f.listxattr(&t.cred)
+}
+
+func (t *TestFS) Removexattr(name, attr string) error {
+	f, err := t.find(name)
+	if err != nil {
+		return pathError("removexattr", name, err)
+	}
+
+	return pathError("removexattr", name, f.removexattr(&t.cred, attr))
+}
+
+func (t *TestFS) Lremovexattr(name, attr string) error {
+	f, err := t.lfind(name)
+	if err != nil {
+		return pathError("lremovexattr", name, err)
+	}
+
+	return pathError("lremovexattr", name, f.removexattr(&t.cred, attr))
+}
+
+func (f *file) Getxattr(attr string) ([]byte, error) {
+	if f == nil {
+		return nil, os.ErrInvalid
+	}
+	if f.isClosed() {
+		return nil, pathError("fgetxattr", f.name, syscall.EBADF)
+	}
+
+	val, err := f.inode.getxattr(f.cred, attr)
+	if err != nil {
+		return nil, pathError("fgetxattr", f.name, err)
+	}
+
+	return val, nil
+}
+
+func (f *file) Setxattr(attr string, data []byte, flags int) error {
+	if f == nil {
+		return os.ErrInvalid
+	}
+	if f.isClosed() {
+		return pathError("fsetxattr", f.name, syscall.EBADF)
+	}
+
+	return pathError("fsetxattr", f.name, f.inode.setxattr(f.cred, attr, data, flags))
+}
+
+func (f *file) Listxattr() ([]string, error) {
+	if f == nil {
+		return nil, os.ErrInvalid
+	}
+	if f.isClosed() {
+		return nil, pathError("flistxattr", f.name, syscall.EBADF)
+	}
+
+	names, err := f.inode.listxattr(f.cred)
+	if err != nil {
+		return nil, pathError("flistxattr", f.name, err)
+	}
+
+	return names, nil
+}
+
+func (f *file) Removexattr(attr string) error {
+	if f == nil {
+		return os.ErrInvalid
+	}
+	if f.isClosed() {
+		return pathError("fremovexattr", f.name, syscall.EBADF)
+	}
+
+	return pathError("fremovexattr", f.name, f.inode.removexattr(f.cred, attr))
+}
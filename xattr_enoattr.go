@@ -0,0 +1,8 @@
+//go:build aix || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package testfs
+
+import "syscall"
+
+// ENOATTR is the error for an extended attribute which does not exist.
+const ENOATTR = syscall.ENOATTR
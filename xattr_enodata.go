@@ -0,0 +1,9 @@
+//go:build !(aix || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package testfs
+
+import "syscall"
+
+// ENOATTR is the error for an extended attribute which does not exist.
+// Linux has no ENOATTR, and gives ENODATA instead.
+const ENOATTR = syscall.ENODATA
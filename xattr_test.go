@@ -0,0 +1,301 @@
+package testfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSetxattr(t *testing.T) {
+	err := fs.dirTree.new(&fs.cred, "testsetxattr", 100, 100, os.FileMode(0644))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Setxattr("/testsetxattr", "user.test", []byte("value"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	val, err := fs.Getxattr("/testsetxattr", "user.test")
+	if err != nil {
+		t.Error(err)
+	}
+	if bytes.Compare(val, []byte("value")) != 0 {
+		t.Error("Bad value")
+	}
+
+	err = fs.Setxattr("/testsetxattr", "user.test", []byte("new"), XATTR_CREATE)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EEXIST {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.Setxattr("/testsetxattr", "user.missing", []byte("new"), XATTR_REPLACE)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ENOATTR {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.Setxattr("/testsetxattr", "user.test", []byte("new"), XATTR_REPLACE)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = fs.Getxattr("/testsetxattr", "user.missing")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ENOATTR {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.Setxattr("/testsetxattr", "bogus.test", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ENOTSUP {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.Setxattr("/testsetxattr", "user.", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EINVAL {
+		t.Error("Bad error status", err)
+	}
+
+	// Other users need write permission for user attributes
+	err = fs.As(200, 200).Setxattr("/testsetxattr", "user.other", []byte("value"), 0)
+	if !os.IsPermission(err) {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestXattrNamespaces(t *testing.T) {
+	err := fs.dirTree.new(&fs.cred, "testxattrns", 100, 100, os.FileMode(0666))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := fs.As(100, 100)
+
+	err = user.Setxattr("/testxattrns", "trusted.test", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	err = user.Setxattr("/testxattrns", "security.selinux", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.Setxattr("/testxattrns", "trusted.test", []byte("value"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Setxattr("/testxattrns", "security.selinux", []byte("system_u:object_r:tmp_t:s0"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = user.Setxattr("/testxattrns", "system.posix_acl_access", []byte("acl"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = user.Setxattr("/testxattrns", "system.other", []byte("acl"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ENOTSUP {
+		t.Error("Bad error status", err)
+	}
+
+	// Anyone may read security attributes, but trusted ones are hidden
+	val, err := user.Getxattr("/testxattrns", "security.selinux")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != "system_u:object_r:tmp_t:s0" {
+		t.Error("Bad value")
+	}
+
+	_, err = user.Getxattr("/testxattrns", "trusted.test")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ENOATTR {
+		t.Error("Bad error status", err)
+	}
+
+	names, err := user.Listxattr("/testxattrns")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(names) != 2 || names[0] != "security.selinux" || names[1] != "system.posix_acl_access" {
+		t.Error("Bad attribute list", names)
+	}
+
+	names, err = fs.Listxattr("/testxattrns")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(names) != 3 {
+		t.Error("Bad attribute list", names)
+	}
+}
+
+func TestLxattr(t *testing.T) {
+	err := fs.Mkdir("/testlxattr", os.FileMode(0755))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Symlink("/testlxattr", "/testlxattrlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User attributes are not allowed on symlinks
+	err = fs.Lsetxattr("/testlxattrlink", "user.test", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPERM {
+		t.Error("Bad error status", err)
+	}
+
+	err = fs.Lsetxattr("/testlxattrlink", "trusted.test", []byte("link"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Setxattr("/testlxattrlink", "trusted.test", []byte("dir"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	val, err := fs.Lgetxattr("/testlxattrlink", "trusted.test")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != "link" {
+		t.Error("Bad value", string(val))
+	}
+
+	val, err = fs.Getxattr("/testlxattr", "trusted.test")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != "dir" {
+		t.Error("Bad value", string(val))
+	}
+
+	err = fs.Lremovexattr("/testlxattrlink", "trusted.test")
+	if err != nil {
+		t.Error(err)
+	}
+
+	names, err := fs.Llistxattr("/testlxattrlink")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(names) != 0 {
+		t.Error("Bad attribute list", names)
+	}
+
+	err = fs.Removexattr("/testlxattr", "trusted.test")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = fs.Removexattr("/testlxattr", "trusted.test")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ENOATTR {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestFileXattr(t *testing.T) {
+	f, err := fs.Create("/testFileXattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Setxattr("user.test", []byte("value"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	val, err := f.Getxattr("user.test")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != "value" {
+		t.Error("Bad value")
+	}
+
+	names, err := f.Listxattr()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(names) != 1 || names[0] != "user.test" {
+		t.Error("Bad attribute list", names)
+	}
+
+	err = f.Removexattr("user.test")
+	if err != nil {
+		t.Error(err)
+	}
+
+	st := f.(*file).inode.Sys().(*Stat_t)
+	if len(st.Xattrs) != 0 {
+		t.Error("Attribute not removed")
+	}
+
+	f.Close()
+
+	err = f.Setxattr("user.test", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EBADF {
+		t.Error("Bad error status", err)
+	}
+}
+
+func TestOSFSXattr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testfs := NewOSFS()
+
+	f, err := testfs.Create(dir + "/xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = testfs.Setxattr(dir+"/xattr", "user.test", []byte("value"), 0)
+	if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.ENOTSUP {
+		t.Skip("Extended attributes not supported by", dir)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := f.Getxattr("user.test")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != "value" {
+		t.Error("Bad value")
+	}
+
+	err = f.Setxattr("user.test", []byte("new"), XATTR_CREATE)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EEXIST {
+		t.Error("Bad error status", err)
+	}
+
+	names, err := testfs.Listxattr(dir + "/xattr")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(names) != 1 || names[0] != "user.test" {
+		t.Error("Bad attribute list", names)
+	}
+
+	err = testfs.Removexattr(dir+"/xattr", "user.test")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = testfs.Lgetxattr(dir+"/xattr", "user.test")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ENOATTR {
+		t.Error("Bad error status", err)
+	}
+}